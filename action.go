@@ -0,0 +1,110 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// actionErrored is the status of a DigitalOcean action that failed.
+// godo only defines constants for in-progress and completed actions.
+const actionErrored = "errored"
+
+// maxPollInterval caps the exponential backoff used when polling the
+// DigitalOcean API.
+const maxPollInterval = 10 * time.Second
+
+// ActionError is returned when a DigitalOcean action finishes in the
+// errored state.
+type ActionError struct {
+	Action *godo.Action
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("%s action %d on %s %d errored",
+		e.Action.Type, e.Action.ID, e.Action.ResourceType, e.Action.ResourceID)
+}
+
+// ActionTimeoutError is returned when the context is done before a
+// DigitalOcean action finishes. The action may still complete.
+type ActionTimeoutError struct {
+	Action *godo.Action
+	Err    error // the context's error
+}
+
+func (e *ActionTimeoutError) Error() string {
+	return fmt.Sprintf("%s action %d on %s %d still %s: %s",
+		e.Action.Type, e.Action.ID, e.Action.ResourceType, e.Action.ResourceID, e.Action.Status, e.Err)
+}
+
+// waitForActionID waits for the action with the provided ID to
+// complete. See waitForAction.
+func waitForActionID(ctx context.Context, client *godo.Client, actionID int) error {
+	action, _, err := client.Actions.Get(ctx, actionID)
+	if err != nil {
+		return err
+	}
+	return waitForAction(ctx, client, action)
+}
+
+// waitForAction polls the DigitalOcean API until the provided action
+// completes. It returns an *ActionError if the action errors, or an
+// *ActionTimeoutError if ctx is done first.
+func waitForAction(ctx context.Context, client *godo.Client, action *godo.Action) error {
+	refresh := false
+	err := poll(ctx, func() (bool, error) {
+		if refresh {
+			a, _, err := client.Actions.Get(ctx, action.ID)
+			if err != nil {
+				return false, err
+			}
+			action = a
+		}
+		refresh = true
+
+		switch action.Status {
+		case godo.ActionCompleted:
+			return true, nil
+		case actionErrored:
+			return false, &ActionError{Action: action}
+		default:
+			return false, nil
+		}
+	})
+	if err != nil && ctx.Err() != nil {
+		return &ActionTimeoutError{Action: action, Err: ctx.Err()}
+	}
+	return err
+}
+
+// poll calls f with exponential backoff until f reports that it's
+// done, f returns an error or ctx is done.
+func poll(ctx context.Context, f func() (done bool, err error)) error {
+	delay := time.Second
+	for {
+		done, err := f()
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxPollInterval {
+			delay = maxPollInterval
+		}
+	}
+}
+
+// isNotFound returns true if err is a DigitalOcean API error
+// indicating the requested resource does not exist.
+func isNotFound(err error) bool {
+	errResp, ok := err.(*godo.ErrorResponse)
+	return ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
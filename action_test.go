@@ -0,0 +1,67 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// newTestClient returns a godo client that sends requests to an
+// httptest server using the provided handler. The caller must close
+// the server.
+func newTestClient(h http.HandlerFunc) (*godo.Client, *httptest.Server) {
+	srv := httptest.NewServer(h)
+	client := godo.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	return client, srv
+}
+
+func TestWaitForAction(t *testing.T) {
+	testCases := []struct {
+		final   string
+		wantErr bool
+	}{
+		{final: godo.ActionCompleted},
+		{final: actionErrored, wantErr: true},
+	}
+	for _, tc := range testCases {
+		var gets int
+		client, srv := newTestClient(func(rw http.ResponseWriter, req *http.Request) {
+			gets++
+			status := godo.ActionInProgress
+			if gets > 1 {
+				status = tc.final
+			}
+			fmt.Fprintf(rw, `{"action": {"id": 7, "status": %q, "type": "attach_volume"}}`, status)
+		})
+
+		err := waitForAction(context.Background(), client, &godo.Action{ID: 7, Status: godo.ActionInProgress})
+		srv.Close()
+		if _, ok := err.(*ActionError); ok != tc.wantErr {
+			t.Errorf("waitForAction with final status %s = %v", tc.final, err)
+		}
+		if gets != 2 {
+			t.Errorf("got %d requests, want 2", gets)
+		}
+	}
+}
+
+func TestWaitForActionTimeout(t *testing.T) {
+	client, srv := newTestClient(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, `{"action": {"id": 7, "status": "in-progress"}}`)
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := waitForAction(ctx, client, &godo.Action{ID: 7, Status: godo.ActionInProgress})
+	if _, ok := err.(*ActionTimeoutError); !ok {
+		t.Errorf("got %v, want *ActionTimeoutError", err)
+	}
+}
@@ -0,0 +1,69 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+// findVolume returns the volume with the provided name in the region,
+// or nil if there isn't one.
+func findVolume(ctx context.Context, client *godo.Client, name, region string) (*godo.Volume, error) {
+	volumes, _, err := client.Storage.ListVolumes(ctx, &godo.ListVolumeParams{
+		Name:   name,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range volumes {
+		if volumes[i].Name == name {
+			return &volumes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// findDroplet returns the dochaincore droplet with the provided name
+// in the region, or nil if there isn't one. Droplet names aren't
+// unique, so it's an error if there's more than one.
+func findDroplet(ctx context.Context, client *godo.Client, name, region string) (*godo.Droplet, error) {
+	droplets, err := listTaggedDroplets(ctx, client, markerTag)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *godo.Droplet
+	for i := range droplets {
+		d := &droplets[i]
+		if d.Name != name || d.Region == nil || d.Region.Slug != region {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("found multiple droplets named %s in %s", name, region)
+		}
+		found = d
+	}
+	return found, nil
+}
+
+// ensureAttached attaches the volume to the droplet unless it's
+// already attached. It's an error if the volume is attached to a
+// different droplet.
+func ensureAttached(ctx context.Context, client *godo.Client, volume *godo.Volume, dropletID int) error {
+	for _, id := range volume.DropletIDs {
+		if id == dropletID {
+			return nil
+		}
+	}
+	if len(volume.DropletIDs) > 0 {
+		return fmt.Errorf("volume %s is attached to droplet %d", volume.Name, volume.DropletIDs[0])
+	}
+
+	action, _, err := client.StorageActions.Attach(ctx, volume.ID, dropletID)
+	if err != nil {
+		return err
+	}
+	return waitForAction(ctx, client, action)
+}
@@ -0,0 +1,133 @@
+package dochaincore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Snapshot describes a block storage snapshot of a Core's volume
+// taken by Backup.
+type Snapshot struct {
+	ID        string
+	Name      string
+	VolumeID  string
+	SizeGB    float64
+	CreatedAt time.Time
+}
+
+// Backup takes a snapshot of the Core's block storage volume. It
+// stops the Chain Core container over SSH so that the blockchain and
+// Postgres data on the volume are consistent, takes the snapshot and
+// then restarts the container, even if the snapshot fails.
+//
+// Backup needs the Core's SSH key, so c must come from Deploy or
+// LoadCore and must not have been finalized.
+func Backup(ctx context.Context, accessToken string, c *Core) (snap *Snapshot, err error) {
+	if c.VolumeID == "" {
+		return nil, errors.New("Core has no volume to back up")
+	}
+
+	_, err = c.Run(ctx, "docker stop dochaincore && sync")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// ctx may be why the snapshot failed, so restart Chain
+		// Core with a fresh one.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_, startErr := c.Run(ctx, "docker start dochaincore")
+		if err == nil && startErr != nil {
+			snap, err = nil, startErr
+		}
+	}()
+
+	client := newClient(ctx, accessToken)
+	s, _, err := client.Storage.CreateSnapshot(ctx, &godo.SnapshotCreateRequest{
+		VolumeID:    c.VolumeID,
+		Name:        backupPrefix(c) + time.Now().UTC().Format(backupTimeFormat),
+		Description: "Chain Core backup",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshotFromGodo(s), nil
+}
+
+// ListBackups returns the snapshots of the Core's volume, oldest
+// first.
+func ListBackups(ctx context.Context, accessToken string, c *Core) ([]*Snapshot, error) {
+	client := newClient(ctx, accessToken)
+
+	var snaps []*Snapshot
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		page, resp, err := client.Storage.ListSnapshots(ctx, c.VolumeID, opt)
+		for i := range page {
+			snaps = append(snaps, snapshotFromGodo(&page[i]))
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].CreatedAt.Before(snaps[j].CreatedAt) })
+	return snaps, nil
+}
+
+// Restore deploys a new Chain Core whose volume is created from the
+// provided snapshot. The volume is sized to fit the snapshot unless
+// VolumeSizeGB is provided. It accepts the same options as Deploy.
+func Restore(ctx context.Context, accessToken string, snapshotID string, opts ...Option) (*Core, error) {
+	client := newClient(ctx, accessToken)
+	s, _, err := client.Storage.GetSnapshot(ctx, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]Option{VolumeSizeGB(int64(s.MinDiskSize))}, opts...)
+	opts = append(opts, VolumeFromSnapshot(snapshotID))
+	return Deploy(ctx, accessToken, opts...)
+}
+
+// backupTimeFormat is the layout of the timestamp that ends the name
+// of each snapshot taken by Backup.
+const backupTimeFormat = "20060102-150405"
+
+// backupPrefix returns the prefix of the names of the Core's backups.
+func backupPrefix(c *Core) string {
+	name := c.Name
+	if name == "" {
+		name = fmt.Sprintf("droplet-%d", c.DropletID)
+	}
+	return name + "-"
+}
+
+// isBackup returns true if the snapshot was taken by Backup, rather
+// than by hand.
+func isBackup(c *Core, s *Snapshot) bool {
+	prefix := backupPrefix(c)
+	if !strings.HasPrefix(s.Name, prefix) {
+		return false
+	}
+	_, err := time.Parse(backupTimeFormat, strings.TrimPrefix(s.Name, prefix))
+	return err == nil
+}
+
+func snapshotFromGodo(s *godo.Snapshot) *Snapshot {
+	snap := &Snapshot{
+		ID:       s.ID,
+		Name:     s.Name,
+		VolumeID: s.ResourceID,
+		SizeGB:   s.SizeGigaBytes,
+	}
+	if t, err := time.Parse(time.RFC3339, s.Created); err == nil {
+		snap.CreatedAt = t
+	}
+	return snap
+}
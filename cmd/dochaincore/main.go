@@ -1,13 +1,46 @@
 // Command dochaincore deploys Chain Core Developer Edition to
 // a Digital Ocean droplet.
+//
+// Usage:
+//
+//	dochaincore [-state file] [-idempotent]        deploy a new Chain Core
+//	dochaincore [-state file] destroy [droplet-id] destroy a Chain Core and its volume
+//	dochaincore list                               list deployed Chain Cores
+//	dochaincore estimate                           estimate the monthly cost of a deploy
+//	dochaincore -state file backup                 snapshot a Chain Core's volume
+//	dochaincore [-state file] backup list [droplet-id]
+//	                                               list a Chain Core's snapshots
+//	dochaincore -state file backup schedule [-interval d] [-keep n] [-max-age d] [-dry-run]
+//	                                               take backups and prune old ones
+//	dochaincore [-state file] restore <snapshot-id> deploy a new Chain Core from a snapshot
+//	dochaincore [-state file] reassign <ip> [droplet-id]
+//	                                               move a floating IP to a Chain Core
+//	dochaincore -state file known-hosts            print a known_hosts line for a Chain Core
+//	dochaincore -state file cp <src> <dst>         copy a file to or from a Chain Core's
+//	                                               droplet; prefix the droplet path with core:
+//
+// If -state is provided, the deployed Core is saved to the file
+// and later commands operate on it. The Core's SSH key is encrypted
+// with the passphrase in the DOCHAINCORE_PASSPHRASE environment
+// variable. With -idempotent, which requires -state, an existing
+// droplet and volume are reused instead of duplicated; if the -state
+// file already exists the saved deployment is resumed. A droplet
+// that wasn't saved to the -state file can't be resumed and must be
+// destroyed first.
 package main
 
 import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/jbowens/dochaincore"
 )
@@ -15,13 +48,60 @@ import (
 var (
 	flagServer = flag.Bool("server", false, "set to run OAuth2 server")
 	flagPort   = flag.Int("port", 8080, "listen port for OAuth2 server")
+	flagState  = flag.String("state", "", "file to save or load the deployed Core")
+	flagIdem   = flag.Bool("idempotent", false, "reuse an existing droplet and volume")
+	flagTags   = flag.String("tags", "", "comma-separated tags to apply to the droplet")
+	flagImage  = flag.String("image", "", "droplet image slug or custom image ID")
+	flagName   = flag.String("name", "", "droplet name; the volume is named <name>-storage")
+	flagRegion = flag.String("region", "", "droplet region slug")
+	flagSize   = flag.String("size", "", "droplet size slug")
+	flagVolume = flag.Int64("volume-size", 0, "volume size in GB")
+	flagVolID  = flag.String("volume", "", "ID of an existing volume to attach instead of creating one")
+	flagVolSnp = flag.String("volume-snapshot", "", "ID of a volume snapshot to create the volume from")
+	flagKeys   = flag.String("ssh-keys", "", `comma-separated IDs or fingerprints of account SSH keys to install, or "none"`)
+	flagUpload = flag.String("upload-key", "", "public key file to register on the account and install")
+	flagIPv6   = flag.Bool("ipv6", true, "give the droplet a public IPv6 address")
+	flagPriv   = flag.Bool("private-networking", false, "enable private networking")
+	flagBackup = flag.Bool("backups", false, "enable weekly droplet backups")
+	flagMon    = flag.Bool("monitoring", true, "install the DigitalOcean monitoring agent")
+	flagFIP    = flag.String("floating-ip", "", `floating IP to assign to the droplet, or "allocate" for a new one`)
+	flagDomain = flag.String("domain", "", "DigitalOcean-managed domain to create DNS records in")
+	flagSubdom = flag.String("subdomain", "", "subdomain to point at the Chain Core; empty for the apex")
+	flagHTTPS  = flag.Bool("https", false, "serve Chain Core over HTTPS with a Let's Encrypt certificate; requires -domain")
+	flagLBCert = flag.String("load-balancer-cert", "", "ID of an account certificate for a load balancer terminating HTTPS; requires -domain and leaves port 1999 open")
+	flagAllow  = flag.String("allow", "", "comma-separated CIDRs allowed to reach Chain Core; empty allows all")
+	flagAllowS = flag.String("allow-ssh", "", "comma-separated CIDRs allowed to reach SSH; empty allows all")
+	flagAllowD = flag.Bool("allow-deployer", true, "add this machine's public IP to -allow and -allow-ssh")
+	flagKeyTyp = flag.String("ssh-key-type", "ed25519", "deployer SSH key type: ed25519, rsa-3072, rsa-4096 or ecdsa-p256")
+	flagKeep   = flag.Bool("keep-deployer-key", false, "keep the deployer SSH key authorized after deploying, so that backup and cp work; requires -state")
 )
 
 func main() {
 	flag.Parse()
 
 	if !*flagServer {
-		createDroplet()
+		switch cmd := flag.Arg(0); cmd {
+		case "", "deploy":
+			createDroplet(dochaincore.Deploy)
+		case "destroy":
+			destroyDroplet(flag.Args()[1:])
+		case "list":
+			listCores()
+		case "estimate":
+			estimateCost()
+		case "backup":
+			backup(flag.Args()[1:])
+		case "restore":
+			restore(flag.Args()[1:])
+		case "reassign":
+			reassign(flag.Args()[1:])
+		case "known-hosts":
+			knownHosts()
+		case "cp":
+			copyFile(flag.Args()[1:])
+		default:
+			fatal(fmt.Errorf("unknown command %q", cmd))
+		}
 		return
 	}
 
@@ -29,6 +109,7 @@ func main() {
 		os.Getenv("DIGITALOCEAN_CLIENT_ID"),
 		os.Getenv("DIGITALOCEAN_CLIENT_SECRET"),
 		os.Getenv("SERVER_HOST"),
+		dochaincore.PricingToken(os.Getenv("DIGITALOCEAN_ACCESS_TOKEN")),
 	)
 	err := http.ListenAndServe(fmt.Sprintf(":%d", *flagPort), handler)
 	if err != nil {
@@ -36,14 +117,57 @@ func main() {
 	}
 }
 
-func createDroplet() {
+// deployFunc deploys a Chain Core. It's either dochaincore.Deploy or
+// a dochaincore.Restore from a snapshot.
+type deployFunc func(ctx context.Context, accessToken string, opts ...dochaincore.Option) (*dochaincore.Core, error)
+
+func createDroplet(deploy deployFunc) {
+	if *flagState != "" {
+		passphrase() // fail before creating anything
+	}
+	if *flagKeep && *flagState == "" {
+		fatal(fmt.Errorf("-keep-deployer-key requires -state, where the key is saved"))
+	}
+
+	opts := deployOptions()
+	var prev *dochaincore.Core
+	if *flagIdem {
+		// Resuming needs the deployer key saved in the state file;
+		// without it an existing droplet couldn't be reached.
+		if *flagState == "" {
+			fatal(fmt.Errorf("-idempotent requires -state, so that an interrupted deploy can be resumed with its saved SSH key"))
+		}
+		opts = append(opts, dochaincore.Idempotent())
+		prev = loadPrevious()
+		if prev != nil && prev.Finalized {
+			fatal(fmt.Errorf("%s can't be resumed: its deployer SSH key was removed after it deployed", *flagState))
+		}
+		if prev != nil {
+			opts = append(opts,
+				dochaincore.DropletName(prev.Name),
+				dochaincore.DropletRegion(prev.Region),
+				dochaincore.DeployerKeyFrom(prev),
+			)
+		}
+	}
+
 	ctx := context.Background()
-	core, err := dochaincore.Deploy(ctx, os.Getenv("DIGITALOCEAN_ACCESS_TOKEN"))
+	core, err := deploy(ctx, os.Getenv("DIGITALOCEAN_ACCESS_TOKEN"), opts...)
 	if err != nil {
 		fatal(err)
 	}
 
-	fmt.Printf("Created DigitalOcean droplet %d.\n", core.DropletID)
+	if prev != nil {
+		mergePrevious(core, prev)
+	}
+
+	for _, r := range core.Adopted {
+		fmt.Printf("Reusing existing %s.\n", r)
+	}
+	for _, r := range core.Created {
+		fmt.Printf("Created %s.\n", r)
+	}
+	saveState(core)
 	fmt.Printf("Waiting for SSH server to start...\n")
 	err = dochaincore.WaitForSSH(ctx, core)
 	if err != nil {
@@ -56,17 +180,456 @@ func createDroplet() {
 		fatal(err)
 	}
 
+	if core.DNSName != "" {
+		fmt.Printf("Waiting for %s to resolve...\n", core.DNSName)
+		err = dochaincore.WaitForDNS(ctx, core)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
 	fmt.Printf("Creating a client token...\n")
 	token, err := dochaincore.CreateClientToken(ctx, core)
 	if err != nil {
 		fatal(err)
 	}
 
-	fmt.Printf("Chain Core listening at: http://%s:1999\n", core.IPv4Address)
+	saveState(core)
+
+	fmt.Printf("Chain Core listening at: %s\n", core.URL())
 	fmt.Printf("Chain Core client token: %s\n", token)
+	fmt.Printf("SSH host key fingerprint: %s\n", core.HostKeyFingerprint)
+
+	if !*flagKeep {
+		fmt.Printf("Removing the deployer SSH key...\n")
+		err = dochaincore.Finalize(ctx, core)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: removing the deployer SSH key: %s\n", err)
+			return
+		}
+		saveState(core)
+	}
+}
+
+// requireSSH exits with an error if the Core's deployer SSH key was
+// removed, since cmd needs it.
+func requireSSH(core *dochaincore.Core, cmd string) {
+	if core.Finalized {
+		fatal(fmt.Errorf("%s needs the deployer SSH key, but the Core was deployed without -keep-deployer-key", cmd))
+	}
+}
+
+// mergePrevious carries the resources recorded on the previously
+// saved Core over to the resumed one, so that destroy still finds
+// everything the earlier run created.
+func mergePrevious(core, prev *dochaincore.Core) {
+	core.SSHKeyIDs = mergeInts(prev.SSHKeyIDs, core.SSHKeyIDs)
+	core.DNSRecordIDs = mergeInts(prev.DNSRecordIDs, core.DNSRecordIDs)
+	core.ClientTokens = append(append([]string{}, prev.ClientTokens...), core.ClientTokens...)
+	if core.LoadBalancerID == "" {
+		core.LoadBalancerID = prev.LoadBalancerID
+		core.LoadBalancerIP = prev.LoadBalancerIP
+	}
+	if prev.AllocatedFloatingIP && prev.FloatingIP == core.FloatingIP {
+		core.AllocatedFloatingIP = true
+	}
+}
+
+// mergeInts returns the elements of a followed by those of b that
+// aren't in a.
+func mergeInts(a, b []int) []int {
+	merged := append([]int{}, a...)
+	for _, x := range b {
+		found := false
+		for _, y := range a {
+			found = found || x == y
+		}
+		if !found {
+			merged = append(merged, x)
+		}
+	}
+	return merged
+}
+
+func knownHosts() {
+	if *flagState == "" {
+		fatal(fmt.Errorf("usage: dochaincore -state file known-hosts"))
+	}
+	core := loadCore(nil, "known-hosts")
+	line, err := core.KnownHostsLine()
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(line)
+}
+
+// remotePrefix marks the droplet side of a cp argument.
+const remotePrefix = "core:"
+
+func copyFile(args []string) {
+	if *flagState == "" || len(args) != 2 ||
+		strings.HasPrefix(args[0], remotePrefix) == strings.HasPrefix(args[1], remotePrefix) {
+		fatal(fmt.Errorf("usage: dochaincore -state file cp <src> <dst>, with %q before the droplet path", remotePrefix))
+	}
+	src, dst := args[0], args[1]
+	core := loadCore(nil, "cp")
+	requireSSH(core, "cp")
+
+	ctx := context.Background()
+	progress := dochaincore.Progress(func(n int64) {
+		fmt.Fprintf(os.Stderr, "\r%d bytes", n)
+	})
+	var err error
+	if strings.HasPrefix(src, remotePrefix) {
+		err = download(ctx, core, strings.TrimPrefix(src, remotePrefix), dst, progress)
+	} else {
+		err = upload(ctx, core, src, strings.TrimPrefix(dst, remotePrefix), progress)
+	}
+	fmt.Fprintln(os.Stderr)
+	core.Close()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// upload copies the local file src, or standard input if src is "-",
+// to the droplet.
+func upload(ctx context.Context, core *dochaincore.Core, src, dst string, progress dochaincore.TransferOption) error {
+	if src == "-" {
+		return core.Upload(ctx, os.Stdin, dst, 0644, progress)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return core.Upload(ctx, f, dst, info.Mode(), progress)
+}
+
+// download copies src on the droplet to the local file dst, or to
+// standard output if dst is "-".
+func download(ctx context.Context, core *dochaincore.Core, src, dst string, progress dochaincore.TransferOption) error {
+	if dst == "-" {
+		return core.Download(ctx, src, os.Stdout, progress)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = core.Download(ctx, src, f, progress)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func destroyDroplet(args []string) {
+	core := loadCore(args, "destroy")
+
+	ctx := context.Background()
+	fmt.Printf("Destroying DigitalOcean droplet %d and its volume...\n", core.DropletID)
+	err := dochaincore.Destroy(ctx, os.Getenv("DIGITALOCEAN_ACCESS_TOKEN"), core)
+	if err != nil {
+		fatal(err)
+	}
+	if *flagState != "" {
+		err = os.Remove(*flagState)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	fmt.Printf("Destroyed DigitalOcean droplet %d.\n", core.DropletID)
+}
+
+func backup(args []string) {
+	ctx := context.Background()
+	accessToken := os.Getenv("DIGITALOCEAN_ACCESS_TOKEN")
+
+	if len(args) > 0 && args[0] == "list" {
+		core := loadCore(args[1:], "backup list")
+		snaps, err := dochaincore.ListBackups(ctx, accessToken, core)
+		if err != nil {
+			fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SNAPSHOT\tNAME\tSIZE\tCREATED")
+		for _, s := range snaps {
+			fmt.Fprintf(w, "%s\t%s\t%.1fGB\t%s\n", s.ID, s.Name, s.SizeGB, s.CreatedAt.Format(time.RFC3339))
+		}
+		w.Flush()
+		return
+	}
+	if len(args) > 0 && args[0] == "schedule" {
+		scheduleBackups(args[1:])
+		return
+	}
+
+	if *flagState == "" || len(args) > 0 {
+		fatal(fmt.Errorf("usage: dochaincore -state file backup"))
+	}
+	core := loadCore(nil, "backup")
+	requireSSH(core, "backup")
+	fmt.Printf("Stopping Chain Core and snapshotting volume %s...\n", core.VolumeID)
+	snap, err := dochaincore.Backup(ctx, accessToken, core)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Created snapshot %s (%s).\n", snap.ID, snap.Name)
+}
+
+// scheduleBackups takes a backup and prunes old ones every interval.
+// With a zero interval it runs once, for use from cron.
+func scheduleBackups(args []string) {
+	fs := flag.NewFlagSet("backup schedule", flag.ExitOnError)
+	interval := fs.Duration("interval", 0, "time between backups; 0 runs once")
+	keep := fs.Int("keep", 0, "number of most recent backups to keep; 0 keeps all")
+	maxAge := fs.Duration("max-age", 0, "prune backups older than this; 0 disables")
+	dryRun := fs.Bool("dry-run", false, "print the backups that would be pruned without changing anything")
+	fs.Parse(args)
+	if *flagState == "" {
+		fatal(fmt.Errorf("usage: dochaincore -state file backup schedule [flags]"))
+	}
+
+	ctx := context.Background()
+	accessToken := os.Getenv("DIGITALOCEAN_ACCESS_TOKEN")
+	core := loadCore(nil, "backup schedule")
+	requireSSH(core, "backup schedule")
+	policy := dochaincore.RetentionPolicy{KeepLast: *keep, MaxAge: *maxAge}
+
+	for {
+		err := backupAndPrune(ctx, accessToken, core, policy, *dryRun)
+		if *interval == 0 {
+			if err != nil {
+				fatal(err)
+			}
+			return
+		}
+		// Keep running after a failure; the next attempt may succeed.
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func backupAndPrune(ctx context.Context, accessToken string, core *dochaincore.Core, policy dochaincore.RetentionPolicy, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("Would back up volume %s.\n", core.VolumeID)
+	} else {
+		// Don't hold the SSH connection open between scheduled
+		// backups.
+		defer core.Close()
+		snap, err := dochaincore.Backup(ctx, accessToken, core)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created snapshot %s (%s).\n", snap.ID, snap.Name)
+	}
+
+	pruned, err := dochaincore.PruneBackups(ctx, accessToken, core, policy, dryRun)
+	for _, s := range pruned {
+		if dryRun {
+			fmt.Printf("Would prune snapshot %s (%s).\n", s.ID, s.Name)
+		} else {
+			fmt.Printf("Pruned snapshot %s (%s).\n", s.ID, s.Name)
+		}
+	}
+	return err
+}
+
+func restore(args []string) {
+	if len(args) != 1 {
+		fatal(fmt.Errorf("usage: dochaincore [-state file] restore <snapshot-id>"))
+	}
+	snapshotID := args[0]
+	createDroplet(func(ctx context.Context, accessToken string, opts ...dochaincore.Option) (*dochaincore.Core, error) {
+		return dochaincore.Restore(ctx, accessToken, snapshotID, opts...)
+	})
+}
+
+func reassign(args []string) {
+	if len(args) < 1 {
+		fatal(fmt.Errorf("usage: dochaincore [-state file] reassign <ip> [droplet-id]"))
+	}
+	ip := args[0]
+	core := loadCore(args[1:], "reassign <ip>")
+
+	ctx := context.Background()
+	fmt.Printf("Assigning floating IP %s to droplet %d...\n", ip, core.DropletID)
+	err := dochaincore.ReassignFloatingIP(ctx, os.Getenv("DIGITALOCEAN_ACCESS_TOKEN"), ip, core)
+	if err != nil {
+		fatal(err)
+	}
+	saveState(core)
+	fmt.Printf("Chain Core listening at: %s\n", core.URL())
+}
+
+// deployOptions returns the deploy options set by command-line flags.
+func deployOptions() []dochaincore.Option {
+	opts := []dochaincore.Option{
+		dochaincore.EnableIPv6(*flagIPv6),
+		dochaincore.PrivateNetworking(*flagPriv),
+		dochaincore.Backups(*flagBackup),
+		dochaincore.Monitoring(*flagMon),
+		dochaincore.SSHKeyType(dochaincore.KeyType(*flagKeyTyp)),
+	}
+	if *flagName != "" {
+		opts = append(opts, dochaincore.DropletName(*flagName))
+	}
+	if *flagRegion != "" {
+		opts = append(opts, dochaincore.DropletRegion(*flagRegion))
+	}
+	if *flagSize != "" {
+		opts = append(opts, dochaincore.DropletSize(*flagSize))
+	}
+	if *flagVolume != 0 {
+		opts = append(opts, dochaincore.VolumeSizeGB(*flagVolume))
+	}
+	switch *flagFIP {
+	case "":
+	case "allocate":
+		opts = append(opts, dochaincore.AllocateFloatingIP())
+	default:
+		opts = append(opts, dochaincore.FloatingIP(*flagFIP))
+	}
+	if *flagDomain != "" {
+		opts = append(opts, dochaincore.DNSName(*flagDomain, *flagSubdom))
+	}
+	if *flagHTTPS {
+		opts = append(opts, dochaincore.HTTPS())
+	}
+	if *flagLBCert != "" {
+		opts = append(opts, dochaincore.LoadBalancer(*flagLBCert))
+	}
+	if *flagAllow != "" {
+		opts = append(opts, dochaincore.AllowedCIDRs(strings.Split(*flagAllow, ",")...))
+	}
+	if *flagAllowS != "" {
+		opts = append(opts, dochaincore.AllowSSHFrom(strings.Split(*flagAllowS, ",")...))
+	}
+	if *flagAllowD {
+		opts = append(opts, dochaincore.AllowDeployer())
+	}
+	if *flagVolID != "" {
+		opts = append(opts, dochaincore.ExistingVolume(*flagVolID))
+	}
+	if *flagVolSnp != "" {
+		opts = append(opts, dochaincore.VolumeFromSnapshot(*flagVolSnp))
+	}
+	if *flagImage != "" {
+		if id, err := strconv.Atoi(*flagImage); err == nil {
+			opts = append(opts, dochaincore.DropletImageID(id))
+		} else {
+			opts = append(opts, dochaincore.DropletImage(*flagImage))
+		}
+	}
+	if *flagTags != "" {
+		opts = append(opts, dochaincore.Tags(strings.Split(*flagTags, ",")...))
+	}
+	switch *flagKeys {
+	case "":
+	case "none":
+		opts = append(opts, dochaincore.NoAccountSSHKeys())
+	default:
+		opts = append(opts, dochaincore.SSHKeys(strings.Split(*flagKeys, ",")...))
+	}
+	if *flagUpload != "" {
+		publicKey, err := ioutil.ReadFile(*flagUpload)
+		if err != nil {
+			fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(*flagUpload), ".pub")
+		opts = append(opts, dochaincore.UploadSSHKey(name, string(publicKey)))
+	}
+	return opts
+}
+
+func estimateCost() {
+	ctx := context.Background()
+	estimate, err := dochaincore.EstimateCost(ctx, os.Getenv("DIGITALOCEAN_ACCESS_TOKEN"), deployOptions()...)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(estimate)
+}
+
+func listCores() {
+	ctx := context.Background()
+	cores, err := dochaincore.List(ctx, os.Getenv("DIGITALOCEAN_ACCESS_TOKEN"))
+	if err != nil {
+		fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DROPLET\tNAME\tSTATUS\tREGION\tIPV4\tIPV6\tAGE")
+	for _, c := range cores {
+		age := time.Since(c.CreatedAt).Truncate(time.Minute)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			c.DropletID, c.Name, c.Status, c.Region, c.IPv4Address, c.IPv6Address, age)
+	}
+	w.Flush()
+}
+
+// loadCore returns the Core identified by the command's droplet ID
+// argument, or the Core saved in the -state file.
+func loadCore(args []string, cmd string) *dochaincore.Core {
+	if *flagState != "" && len(args) == 0 {
+		core, err := dochaincore.LoadCore(*flagState, passphrase())
+		if err != nil {
+			fatal(err)
+		}
+		return core
+	}
+	if len(args) != 1 {
+		fatal(fmt.Errorf("usage: dochaincore [-state file] %s [droplet-id]", cmd))
+	}
+	dropletID, err := strconv.Atoi(args[0])
+	if err != nil {
+		fatal(fmt.Errorf("invalid droplet ID %q", args[0]))
+	}
+	return &dochaincore.Core{DropletID: dropletID}
+}
+
+// loadPrevious returns the Core saved in the -state file, or nil if
+// there isn't one yet.
+func loadPrevious() *dochaincore.Core {
+	if *flagState == "" {
+		return nil
+	}
+	if _, err := os.Stat(*flagState); os.IsNotExist(err) {
+		return nil
+	}
+	core, err := dochaincore.LoadCore(*flagState, passphrase())
+	if err != nil {
+		fatal(err)
+	}
+	return core
+}
+
+// saveState writes core to the -state file, if there is one.
+func saveState(core *dochaincore.Core) {
+	if *flagState == "" {
+		return
+	}
+	err := dochaincore.SaveCore(*flagState, core, passphrase())
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func passphrase() []byte {
+	p := os.Getenv("DOCHAINCORE_PASSPHRASE")
+	if p == "" {
+		fatal(fmt.Errorf("DOCHAINCORE_PASSPHRASE must be set when using -state"))
+	}
+	return []byte(p)
 }
 
 func fatal(err error) {
-	fmt.Fprintf(os.Stderr, err.Error())
+	fmt.Fprintln(os.Stderr, err.Error())
 	os.Exit(1)
 }
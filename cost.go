@@ -0,0 +1,84 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+// volumePricePerGBMonthly is DigitalOcean's block storage price in
+// USD per gigabyte per month. The API doesn't expose storage pricing.
+const volumePricePerGBMonthly = 0.10
+
+// loadBalancerPriceMonthly is DigitalOcean's load balancer price in
+// USD per month.
+const loadBalancerPriceMonthly = 12.00
+
+// backupsPriceRatio is the price of droplet backups as a fraction of
+// the droplet's price.
+const backupsPriceRatio = 0.20
+
+// CostEstimate is a breakdown of the approximate monthly cost, in USD,
+// of a Chain Core deployment.
+type CostEstimate struct {
+	DropletSize    string
+	DropletMonthly float64
+	BackupsMonthly float64
+	VolumeSizeGB   int64
+	VolumeMonthly  float64
+
+	LoadBalancerMonthly float64
+	TotalMonthly        float64
+}
+
+func (e *CostEstimate) String() string {
+	s := fmt.Sprintf("droplet %s: $%.2f/month\n", e.DropletSize, e.DropletMonthly)
+	if e.BackupsMonthly > 0 {
+		s += fmt.Sprintf("backups: $%.2f/month\n", e.BackupsMonthly)
+	}
+	s += fmt.Sprintf("volume %dGB: $%.2f/month\n", e.VolumeSizeGB, e.VolumeMonthly)
+	if e.LoadBalancerMonthly > 0 {
+		s += fmt.Sprintf("load balancer: $%.2f/month\n", e.LoadBalancerMonthly)
+	}
+	return s + fmt.Sprintf("total: $%.2f/month", e.TotalMonthly)
+}
+
+// EstimateCost returns the approximate monthly cost of deploying a
+// Chain Core with the provided options, using the droplet size's
+// current monthly price and DigitalOcean's block storage pricing.
+func EstimateCost(ctx context.Context, accessToken string, opts ...Option) (*CostEstimate, error) {
+	opt := buildOptions(opts)
+	return estimateCost(ctx, newClient(ctx, accessToken), &opt)
+}
+
+func estimateCost(ctx context.Context, client *godo.Client, opt *options) (*CostEstimate, error) {
+	sizes, err := listSizes(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	var size *godo.Size
+	for i := range sizes {
+		if sizes[i].Slug == opt.dropletSize {
+			size = &sizes[i]
+		}
+	}
+	if size == nil {
+		return nil, fmt.Errorf("droplet size %q does not exist", opt.dropletSize)
+	}
+
+	e := &CostEstimate{
+		DropletSize:    size.Slug,
+		DropletMonthly: size.PriceMonthly,
+		VolumeSizeGB:   opt.volumeSize,
+		VolumeMonthly:  float64(opt.volumeSize) * volumePricePerGBMonthly,
+	}
+	if opt.backups {
+		e.BackupsMonthly = size.PriceMonthly * backupsPriceRatio
+	}
+	if opt.lbCertificateID != "" {
+		e.LoadBalancerMonthly = loadBalancerPriceMonthly
+	}
+	e.TotalMonthly = e.DropletMonthly + e.BackupsMonthly + e.VolumeMonthly + e.LoadBalancerMonthly
+	return e, nil
+}
@@ -2,24 +2,87 @@ package dochaincore
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/digitalocean/godo"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/oauth2"
 )
 
+// Core describes a Chain Core deployed to a DigitalOcean droplet.
+// Use SaveCore and LoadCore to persist it between processes.
 type Core struct {
-	DropletID   int
-	IPv4Address string
-	IPv6Address string
+	Name        string `json:"name"`
+	DropletID   int    `json:"droplet_id"`
+	VolumeID    string `json:"volume_id"`
+	IPv4Address string `json:"ipv4_address"`
+	IPv6Address string `json:"ipv6_address"`
+
+	// PrivateIPv4Address is only set when the droplet was created
+	// with private networking.
+	PrivateIPv4Address string `json:"private_ipv4_address,omitempty"`
+
+	// FloatingIP is the Core's stable address, if it has one.
+	// AllocatedFloatingIP is true if Deploy allocated it, in which
+	// case Destroy releases it.
+	FloatingIP          string `json:"floating_ip,omitempty"`
+	AllocatedFloatingIP bool   `json:"allocated_floating_ip,omitempty"`
+
+	// DNSName is the Core's fully qualified DNS name, if it has one.
+	// DNSRecordIDs identifies its records in DNSDomain.
+	DNSName      string `json:"dns_name,omitempty"`
+	DNSDomain    string `json:"dns_domain,omitempty"`
+	DNSRecordIDs []int  `json:"dns_record_ids,omitempty"`
+
+	// TLS is true if Chain Core is served over HTTPS, either by a
+	// proxy on the droplet or by the load balancer LoadBalancerID.
+	TLS            bool   `json:"tls,omitempty"`
+	LoadBalancerID string `json:"load_balancer_id,omitempty"`
+	LoadBalancerIP string `json:"load_balancer_ip,omitempty"`
+
+	Region    string    `json:"region"`
+	Size      string    `json:"size"`
+	Image     string    `json:"image"` // slug, or ID for custom images
+	CreatedAt time.Time `json:"created_at"`
+	Tags      []string  `json:"tags,omitempty"`
+
+	// Status is the droplet's status as of the last call to Deploy
+	// or List, for example "new" or "active".
+	Status string `json:"status,omitempty"`
+
+	// SSHKeyIDs holds the IDs of any SSH keys registered on the
+	// DigitalOcean account for this Core. Destroy removes them.
+	SSHKeyIDs []int `json:"ssh_key_ids,omitempty"`
+
+	// ClientTokens holds the client tokens created by
+	// CreateClientToken.
+	ClientTokens []string `json:"client_tokens,omitempty"`
+
+	// HostKey is the droplet's SSH host public key in authorized_keys
+	// format. Deploy generates the host key and SSH connections to
+	// the droplet fail unless it presents this key.
+	HostKey            string `json:"host_key,omitempty"`
+	HostKeyFingerprint string `json:"host_key_fingerprint,omitempty"`
+
+	// Finalized is true once Finalize has removed the deployer's SSH
+	// key from the droplet.
+	Finalized bool `json:"finalized,omitempty"`
+
+	// Adopted and Created describe the resources that Deploy reused
+	// and created, respectively. Deploy only adopts existing
+	// resources when the Idempotent option is provided.
+	Adopted []string `json:"-"`
+	Created []string `json:"-"`
 
 	ssh *sshKeyPair
+
+	mu     sync.Mutex // protects client and ClientTokens
+	client *ssh.Client
 }
 
 type Option func(*options)
@@ -40,133 +103,411 @@ func VolumeSizeGB(gb int64) Option {
 	return func(opt *options) { opt.volumeSize = gb }
 }
 
+// NoRollback disables deleting the resources Deploy created when
+// deployment fails. It's useful for debugging a failed deployment.
+func NoRollback() Option {
+	return func(opt *options) { opt.noRollback = true }
+}
+
+// EnableIPv6 sets whether the droplet gets a public IPv6 address.
+// It's enabled by default. Disable it in regions or accounts without
+// IPv6 support.
+func EnableIPv6(enabled bool) Option {
+	return func(opt *options) { opt.ipv6 = enabled }
+}
+
+// PrivateNetworking sets whether the droplet joins the region's
+// private network. When enabled, Core.PrivateIPv4Address is set.
+func PrivateNetworking(enabled bool) Option {
+	return func(opt *options) { opt.privateNetworking = enabled }
+}
+
+// Backups sets whether DigitalOcean's weekly droplet backups are
+// enabled. Backups don't include the block storage volume.
+func Backups(enabled bool) Option {
+	return func(opt *options) { opt.backups = enabled }
+}
+
+// Monitoring sets whether the DigitalOcean monitoring agent is
+// installed on the droplet. It's enabled by default.
+func Monitoring(enabled bool) Option {
+	return func(opt *options) { opt.monitoring = enabled }
+}
+
+// Tags applies the provided tags to the droplet. DigitalOcean doesn't
+// support tagging volumes, so only the droplet is tagged. Deploy
+// always adds the "dochaincore" tag so that List can find the Core.
+func Tags(tags ...string) Option {
+	return func(opt *options) { opt.tags = append(opt.tags, tags...) }
+}
+
+// Idempotent makes Deploy reuse an existing droplet and volume with
+// the configured names instead of creating duplicates. Only missing
+// resources are created. A reused droplet only trusts the SSH key it
+// was created with, so combine Idempotent with DeployerKeyFrom to
+// resume a deployment saved with SaveCore. Without DeployerKeyFrom,
+// Deploy returns a *ValidationError if the droplet already exists.
+func Idempotent() Option {
+	return func(opt *options) { opt.idempotent = true }
+}
+
+// DeployerKeyFrom makes Deploy authorize the SSH key of a previously
+// deployed Core instead of generating a new one. If Deploy adopts
+// the Core's droplet, it also trusts the Core's pinned host key.
+// Deploy returns a *ValidationError if c has no SSH key, because it
+// was finalized or wasn't loaded with LoadCore.
+func DeployerKeyFrom(c *Core) Option {
+	return func(opt *options) {
+		opt.keypair = c.ssh
+		opt.hostKey = c.HostKey
+		opt.missingDeployerKey = c.ssh == nil
+	}
+}
+
 type options struct {
-	dropletName   string
-	dropletRegion string
-	dropletSize   string
-	volumeSize    int64
+	dropletName        string
+	dropletRegion      string
+	dropletSize        string
+	volumeSize         int64
+	existingVolume     string
+	volumeSnapshot     string
+	floatingIP         string
+	allocateFloatingIP bool
+	dnsDomain          string
+	dnsSubdomain       string
+	https              bool
+	lbCertificateID    string
+	allowedCIDRs       []string
+	sshCIDRs           []string
+	allowDeployer      bool
+	noRollback         bool
+	dropletImage       godo.DropletCreateImage
+	ipv6               bool
+	privateNetworking  bool
+	monitoring         bool
+	backups            bool
+	idempotent         bool
+	keypair            *sshKeyPair
+	missingDeployerKey bool
+	sshKeyType         KeyType
+	hostKey            string
+	tags               []string
+	selectKeys         bool
+	accountKeys        []string
+	uploadKeys         []godo.KeyCreateRequest
 }
 
-// Deploy builds and deploys an instance of Chain Core on a DigitalOcean
-// droplet. It requires a DigitalOcean access token and optionally takes
-// a variadic number of configuration options.
-func Deploy(ctx context.Context, accessToken string, opts ...Option) (*Core, error) {
+// provisionTimeout bounds how long Deploy waits for a droplet to be
+// provisioned.
+const provisionTimeout = 5 * time.Minute
+
+func buildOptions(opts []Option) options {
 	opt := options{
 		dropletName:   "chain-core",
 		dropletRegion: "sfo2",
-		dropletSize:   "1gb",
+		dropletSize:   "s-1vcpu-1gb",
+		dropletImage:  godo.DropletCreateImage{Slug: defaultImage},
 		volumeSize:    100,
+		ipv6:          true,
+		monitoring:    true,
+		sshKeyType:    KeyTypeEd25519,
 	}
 	for _, o := range opts {
 		o(&opt)
 	}
+	return opt
+}
 
-	keypair, err := createSSHKeyPair()
-	if err != nil {
-		return nil, err
-	}
+// Deploy builds and deploys an instance of Chain Core on a DigitalOcean
+// droplet. It requires a DigitalOcean access token and optionally takes
+// a variadic number of configuration options.
+//
+// Deploy validates the options before creating anything, returning
+// a *ValidationError if they're invalid. If Deploy fails after
+// creating resources, it deletes them in reverse order and returns
+// a *DeployError describing the rollback.
+func Deploy(ctx context.Context, accessToken string, opts ...Option) (core *Core, err error) {
+	opt := buildOptions(opts)
 
-	oauthClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: accessToken},
-	))
-	client := godo.NewClient(oauthClient)
-
-	// Blockchains require storage. Make a volume that we can attach
-	// to the droplet. Chain Core will store blockchain data on the volume.
-	volume, _, err := client.Storage.CreateVolume(ctx, &godo.VolumeCreateRequest{
-		Region:        opt.dropletRegion,
-		Name:          fmt.Sprintf("%s-storage", opt.dropletName),
-		Description:   "Chain Core storage volume",
-		SizeGigaBytes: opt.volumeSize,
-	})
+	client := newClient(ctx, accessToken)
+	err = validate(ctx, client, &opt)
 	if err != nil {
 		return nil, err
 	}
 
-	// Query all the SSH keys on the account so we can include them
-	// in the droplet.
-	sshKeys, _, err := client.Keys.List(ctx, nil)
-	if err != nil {
-		return nil, err
+	keypair := opt.keypair
+	if keypair == nil {
+		keypair, err = createSSHKeyPair(opt.sshKeyType)
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	// Build user data to initialize the droplet as a Chain Core
-	// instance.
-	userData, err := buildUserData(keypair)
+	fw, err := buildFirewall(ctx, &opt)
 	if err != nil {
 		return nil, err
 	}
 
-	// Launch the DigitalOcean droplet.
-	createRequest := &godo.DropletCreateRequest{
-		Name:       opt.dropletName,
-		Region:     opt.dropletRegion,
-		Size:       opt.dropletSize,
-		IPv6:       true,
-		Monitoring: true,
-		UserData:   userData,
-		Image: godo.DropletCreateImage{
-			Slug: "ubuntu-17-04-x64",
-		},
-		Volumes: []godo.DropletCreateVolume{
-			{ID: volume.ID},
-		},
-	}
-	for _, key := range sshKeys {
-		keyToAdd := godo.DropletCreateSSHKey{ID: key.ID}
-		createRequest.SSHKeys = append(createRequest.SSHKeys, keyToAdd)
+	// Track every resource we create so that we can roll back
+	// if a later step fails or the context is cancelled.
+	var created []createdResource
+	var adopted []string
+	defer func() {
+		if err != nil {
+			core, err = nil, rollback(created, !opt.noRollback, err)
+		}
+	}()
+
+	volumeName := fmt.Sprintf("%s-storage", opt.dropletName)
+	var volume *godo.Volume
+	var droplet *godo.Droplet
+	if opt.idempotent {
+		volume, err = findVolume(ctx, client, volumeName, opt.dropletRegion)
+		if err != nil {
+			return nil, err
+		}
+		droplet, err = findDroplet(ctx, client, opt.dropletName, opt.dropletRegion)
+		if err != nil {
+			return nil, err
+		}
+		if droplet != nil && opt.keypair == nil {
+			// A freshly generated key would never be authorized on
+			// the existing droplet, so the Core couldn't be reached.
+			verr := new(ValidationError)
+			verr.addf("droplet %s already exists; use DeployerKeyFrom to resume its deployment", opt.dropletName)
+			return nil, verr
+		}
 	}
 
-	droplet, _, err := client.Droplets.Create(ctx, createRequest)
-	if err != nil {
-		return nil, err
+	if volume == nil && opt.existingVolume != "" {
+		volume, _, err = client.Storage.GetVolume(ctx, opt.existingVolume)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	core := &Core{
-		DropletID: droplet.ID,
-		ssh:       keypair,
+	if volume != nil {
+		adopted = append(adopted, fmt.Sprintf("volume %s", volume.ID))
+		if droplet == nil && len(volume.DropletIDs) > 0 {
+			return nil, fmt.Errorf("volume %s is attached to droplet %d", volume.Name, volume.DropletIDs[0])
+		}
+	} else {
+		// Blockchains require storage. Make a volume that we can attach
+		// to the droplet. Chain Core will store blockchain data on the volume.
+		volume, err = createVolume(ctx, client, volumeName, &opt)
+		if err != nil {
+			return nil, err
+		}
+		volumeID := volume.ID
+		created = append(created, createdResource{
+			name:    fmt.Sprintf("volume %s", volumeID),
+			destroy: func(ctx context.Context) error { return destroyVolume(ctx, client, volumeID) },
+		})
 	}
 
-	// A just-created droplet won't have any of the network IP addresses
-	// quite yet. We have to poll until the droplet is provisioned and
-	// they're populated.
-	for attempt := 1; core.IPv4Address == "" || core.IPv6Address == ""; attempt++ {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-time.After(time.Duration(attempt) * time.Second): /// linear backoff
+	image := imageName(opt.dropletImage)
+	createdAt := time.Now().UTC()
+	var registeredKeyIDs []int
+	var createActionID int
+	hostKey := opt.hostKey
+	if droplet != nil {
+		adopted = append(adopted, fmt.Sprintf("droplet %d", droplet.ID))
+		if droplet.Image != nil {
+			image = droplet.Image.Slug
+		}
+		if t, err := time.Parse(time.RFC3339, droplet.Created); err == nil {
+			createdAt = t
+		}
+		err = ensureAttached(ctx, client, volume, droplet.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Collect the SSH keys on the account to include in the
+		// droplet, registering any new keys first.
+		var sshKeys []godo.DropletCreateSSHKey
+		sshKeys, registeredKeyIDs, err = dropletSSHKeys(ctx, client, &opt, &created)
+		if err != nil {
+			return nil, err
 		}
 
-		droplet, _, err := client.Droplets.Get(ctx, core.DropletID)
+		// Generate the droplet's host key so that we can verify
+		// its identity when we connect.
+		hostKeys, err := createHostKeyPair()
 		if err != nil {
 			return nil, err
 		}
+		hostKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKeys.publicKey)))
 
-		for _, nv4 := range droplet.Networks.V4 {
-			if nv4.IPAddress != "" {
-				core.IPv4Address = nv4.IPAddress
-			}
+		// Build user data to initialize the droplet as a Chain Core
+		// instance.
+		params := newUserDataParams(keypair, hostKeys, volume.Name)
+		if opt.https {
+			params.TLSDomain = dnsName(opt.dnsDomain, opt.dnsSubdomain)
 		}
-		for _, nv6 := range droplet.Networks.V6 {
-			if nv6.IPAddress != "" {
-				core.IPv6Address = nv6.IPAddress
+		params.Firewall = fw
+		userData, err := buildUserData(params)
+		if err != nil {
+			return nil, err
+		}
+
+		// Launch the DigitalOcean droplet.
+		createRequest := &godo.DropletCreateRequest{
+			Name:              opt.dropletName,
+			Region:            opt.dropletRegion,
+			Size:              opt.dropletSize,
+			IPv6:              opt.ipv6,
+			PrivateNetworking: opt.privateNetworking,
+			Backups:           opt.backups,
+			Monitoring:        opt.monitoring,
+			UserData:          userData,
+			Image:             opt.dropletImage,
+			Volumes: []godo.DropletCreateVolume{
+				{ID: volume.ID},
+			},
+			SSHKeys: sshKeys,
+			Tags:    dropletTags(opt.tags),
+		}
+
+		var resp *godo.Response
+		droplet, resp, err = client.Droplets.Create(ctx, createRequest)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Links != nil {
+			for _, a := range resp.Links.Actions {
+				if a.Rel == "create" {
+					createActionID = a.ID
+				}
 			}
 		}
-		if attempt >= 10 {
-			return nil, fmt.Errorf("timeout waiting for provisioning of droplet %d", core.DropletID)
+		dropletID := droplet.ID
+		created = append(created, createdResource{
+			name:    fmt.Sprintf("droplet %d", dropletID),
+			destroy: func(ctx context.Context) error { return destroyDroplet(ctx, client, dropletID) },
+		})
+	}
+
+	core = &Core{
+		Name:      opt.dropletName,
+		DropletID: droplet.ID,
+		VolumeID:  volume.ID,
+		Region:    opt.dropletRegion,
+		Size:      opt.dropletSize,
+		Image:     image,
+		CreatedAt: createdAt,
+		Tags:      droplet.Tags,
+		Status:    droplet.Status,
+		SSHKeyIDs: registeredKeyIDs,
+		TLS:       opt.https,
+		HostKey:   hostKey,
+		Adopted:   adopted,
+		ssh:       keypair,
+	}
+	if k, err := core.hostKey(); err == nil {
+		core.HostKeyFingerprint = ssh.FingerprintSHA256(k)
+	}
+	for _, r := range created {
+		core.Created = append(core.Created, r.name)
+	}
+
+	// Follow the droplet's create action, then wait for the network
+	// addresses we asked for to be populated.
+	waitCtx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+	if createActionID != 0 {
+		err = waitForActionID(waitCtx, client, createActionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = poll(waitCtx, func() (bool, error) {
+		droplet, _, err := client.Droplets.Get(waitCtx, core.DropletID)
+		if err != nil {
+			return false, err
 		}
+		core.Status = droplet.Status
+		core.IPv4Address, _ = droplet.PublicIPv4()
+		core.IPv6Address, _ = droplet.PublicIPv6()
+		core.PrivateIPv4Address, _ = droplet.PrivateIPv4()
+		ready := core.IPv4Address != "" &&
+			(!opt.ipv6 || core.IPv6Address != "") &&
+			(!opt.privateNetworking || core.PrivateIPv4Address != "")
+		return ready, nil
+	})
+	if err != nil && waitCtx.Err() != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("timeout waiting for provisioning of droplet %d", core.DropletID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = setupFloatingIP(ctx, client, core, &opt, &created)
+	if err != nil {
+		return nil, err
+	}
+	err = setupLoadBalancer(ctx, client, core, &opt, &created)
+	if err != nil {
+		return nil, err
+	}
+	err = setupDNS(ctx, client, core, &opt, &created)
+	if err != nil {
+		return nil, err
 	}
 	return core, nil
 }
 
+func newClient(ctx context.Context, accessToken string) *godo.Client {
+	oauthClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: accessToken},
+	))
+	return godo.NewClient(oauthClient)
+}
+
 // WaitForSSH waits until port 22 on the provided Chain Core's host is opened.
 func WaitForSSH(ctx context.Context, c *Core) error {
 	return waitForPort(ctx, c.IPv4Address, 22)
 }
 
-// WaitForHTTP waits until Chain Core begins listening on port 1999.
+// WaitForHTTP waits until Chain Core begins listening on port 1999
+// at the Core's public host, or until the HTTPS proxy begins
+// listening on port 443 if the droplet terminates TLS itself.
 func WaitForHTTP(ctx context.Context, c *Core) error {
-	return waitForPort(ctx, c.IPv4Address, 1999)
+	if c.TLS && c.LoadBalancerID == "" {
+		return waitForPort(ctx, c.Host(), 443)
+	}
+	return waitForPort(ctx, c.Host(), 1999)
+}
+
+// Host returns the public address clients should use to reach the
+// Core: its floating IP if it has one, otherwise the droplet's IPv4
+// address.
+func (c *Core) Host() string {
+	if c.FloatingIP != "" {
+		return c.FloatingIP
+	}
+	return c.IPv4Address
+}
+
+// URL returns the base URL of the Core's Chain Core API and dashboard,
+// using its DNS name if it has one.
+func (c *Core) URL() string {
+	host := c.entryIP()
+	if c.DNSName != "" {
+		host = c.DNSName
+	}
+	if c.TLS {
+		return "https://" + host
+	}
+	return fmt.Sprintf("http://%s:1999", host)
+}
+
+// entryIP returns the IP address that the Core's DNS records point
+// at: its load balancer's if it has one, otherwise Host.
+func (c *Core) entryIP() string {
+	if c.LoadBalancerIP != "" {
+		return c.LoadBalancerIP
+	}
+	return c.Host()
 }
 
 func waitForPort(ctx context.Context, host string, port int) (err error) {
@@ -176,7 +517,7 @@ func waitForPort(ctx context.Context, host string, port int) (err error) {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-time.After(5 * time.Second):
-			conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", host, port))
+			conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 		}
 	}
 	conn.Close()
@@ -184,40 +525,21 @@ func waitForPort(ctx context.Context, host string, port int) (err error) {
 }
 
 // CreateClientToken sets up a Chain Core client token for the
-// provided Core.
+// provided Core and records it in c.ClientTokens.
 func CreateClientToken(ctx context.Context, c *Core) (string, error) {
-	const createClientToken = `
-	docker exec dochaincore /usr/bin/chain/corectl create-token do client-readwrite
-	`
-	// TODO(jackson): remove the ssh key from authorized_keys before
-	// closing the SSH session.
-
-	session, err := connect(ctx, c.IPv4Address, c.ssh)
-	if err != nil {
-		return "", err
-	}
-	defer session.Close()
+	const createClientToken = `docker exec dochaincore /usr/bin/chain/corectl create-token do client-readwrite`
 
-	rOut, err := session.StdoutPipe()
+	out, err := c.Run(ctx, createClientToken)
 	if err != nil {
 		return "", err
 	}
-	rErr, err := session.StderrPipe()
-	if err != nil {
-		return "", err
+	token := strings.TrimSpace(out)
+	if !strings.HasPrefix(token, "do:") {
+		return "", fmt.Errorf("unexpected corectl output %q", out)
 	}
 
-	err = session.Start(createClientToken)
-	if err != nil {
-		return "", err
-	}
-	outputBytes, err := ioutil.ReadAll(io.MultiReader(rOut, rErr))
-	if err != nil {
-		return "", err
-	}
-	output := strings.TrimSpace(string(outputBytes))
-	if !strings.HasPrefix(output, "do:") {
-		return "", errors.New(output)
-	}
-	return output, nil
+	c.mu.Lock()
+	c.ClientTokens = append(c.ClientTokens, token)
+	c.mu.Unlock()
+	return token, nil
 }
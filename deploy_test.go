@@ -0,0 +1,53 @@
+package dochaincore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreateClientToken(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+
+	// The test server runs commands locally, so stand in for docker
+	// with a script that prints $OUTPUT.
+	dir, err := ioutil.TempDir("", "dochaincore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "docker"), []byte("#!/bin/sh\necho \"$OUTPUT\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer os.Unsetenv("OUTPUT")
+
+	testCases := []struct {
+		output  string
+		wantErr bool
+	}{
+		{output: "do:6de76c428a8ce9805777a60fffed2188"},
+		{output: "unauthorized", wantErr: true},
+		{output: "", wantErr: true},
+	}
+	var want []string
+	for _, tc := range testCases {
+		os.Setenv("OUTPUT", tc.output)
+		token, err := CreateClientToken(context.Background(), c)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: got token %q and error %v", tc.output, token, err)
+		}
+		if err == nil {
+			want = append(want, token)
+		}
+	}
+	if !reflect.DeepEqual(c.ClientTokens, want) {
+		t.Errorf("got client tokens %q, want %q", c.ClientTokens, want)
+	}
+}
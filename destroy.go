@@ -0,0 +1,145 @@
+package dochaincore
+
+import (
+	"context"
+
+	"github.com/digitalocean/godo"
+)
+
+// Destroy tears down a Chain Core deployed by Deploy. It deletes the
+// droplet, which detaches the Core's block storage volume, then
+// deletes the volume and removes any SSH keys that Deploy registered
+// on the account. A floating IP allocated by Deploy is released and
+// the Core's DNS records are removed, unless they've since been
+// pointed at another droplet. The Core's load balancer, if any, is
+// deleted. Destroy waits for each DigitalOcean action to complete
+// before returning.
+//
+// If c.VolumeID is empty, every volume attached to the droplet is
+// destroyed. Resources that no longer exist are skipped, so it's safe
+// to call Destroy again after a partial failure.
+func Destroy(ctx context.Context, accessToken string, c *Core) error {
+	return destroy(ctx, newClient(ctx, accessToken), c)
+}
+
+func destroy(ctx context.Context, client *godo.Client, c *Core) error {
+	volumeIDs := []string{}
+	if c.VolumeID != "" {
+		volumeIDs = append(volumeIDs, c.VolumeID)
+	} else {
+		droplet, _, err := client.Droplets.Get(ctx, c.DropletID)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if droplet != nil {
+			volumeIDs = append(volumeIDs, droplet.VolumeIDs...)
+		}
+	}
+
+	err := deleteDNSRecords(ctx, client, c)
+	if err != nil {
+		return err
+	}
+	if c.AllocatedFloatingIP {
+		err := releaseFloatingIP(ctx, client, c.FloatingIP, c.DropletID)
+		if err != nil {
+			return err
+		}
+	}
+	if c.LoadBalancerID != "" {
+		err := deleteLoadBalancer(ctx, client, c.LoadBalancerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the droplet before its volumes so that they're never
+	// detached while Chain Core still has them mounted.
+	err = destroyDroplet(ctx, client, c.DropletID)
+	if err != nil {
+		return err
+	}
+	for _, volumeID := range volumeIDs {
+		err := destroyVolume(ctx, client, volumeID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, keyID := range c.SSHKeyIDs {
+		err := destroySSHKey(ctx, client, keyID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destroyVolume detaches the volume from any droplets and deletes it.
+// Deleting a droplet detaches its volumes, but the volume may still
+// list the droplet for a short while afterwards.
+func destroyVolume(ctx context.Context, client *godo.Client, volumeID string) error {
+	volume, _, err := client.Storage.GetVolume(ctx, volumeID)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Volumes can't be deleted while they're attached to a droplet,
+	// so detach them first.
+	for _, dropletID := range volume.DropletIDs {
+		action, _, err := client.StorageActions.DetachByDropletID(ctx, volumeID, dropletID)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = waitForAction(ctx, client, action)
+		if err != nil {
+			return err
+		}
+	}
+	err = poll(ctx, func() (bool, error) {
+		volume, _, err := client.Storage.GetVolume(ctx, volumeID)
+		if isNotFound(err) {
+			return true, nil
+		}
+		return err == nil && len(volume.DropletIDs) == 0, err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Storage.DeleteVolume(ctx, volumeID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// destroyDroplet deletes the droplet and waits until it's gone.
+func destroyDroplet(ctx context.Context, client *godo.Client, dropletID int) error {
+	// Deleting a droplet doesn't return an action, so poll until
+	// the droplet is gone.
+	_, err := client.Droplets.Delete(ctx, dropletID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return poll(ctx, func() (bool, error) {
+		_, _, err := client.Droplets.Get(ctx, dropletID)
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// destroySSHKey removes the SSH key from the account.
+func destroySSHKey(ctx context.Context, client *godo.Client, keyID int) error {
+	_, err := client.Keys.DeleteByID(ctx, keyID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
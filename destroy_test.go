@@ -0,0 +1,95 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDestroy(t *testing.T) {
+	testCases := []struct {
+		desc string
+		// lingering is the number of times the volume still lists
+		// the droplet after the droplet is deleted.
+		lingering int
+	}{
+		{desc: "detached immediately"},
+		{desc: "detached late", lingering: 1},
+	}
+	for _, tc := range testCases {
+		var (
+			dropletExists = true
+			volumeExists  = true
+			lingering     = tc.lingering
+			changes       []string
+		)
+		notFound := func(rw http.ResponseWriter) {
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `{"id": "not_found", "message": "not found"}`)
+		}
+		client, srv := newTestClient(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != "GET" {
+				changes = append(changes, req.Method+" "+req.URL.Path)
+			}
+			switch req.Method + " " + req.URL.Path {
+			case "GET /v2/droplets/1":
+				if !dropletExists {
+					notFound(rw)
+					return
+				}
+				fmt.Fprint(rw, `{"droplet": {"id": 1}}`)
+			case "DELETE /v2/droplets/1":
+				dropletExists = false
+				rw.WriteHeader(http.StatusNoContent)
+			case "GET /v2/volumes/vol":
+				if !volumeExists {
+					notFound(rw)
+					return
+				}
+				attached := dropletExists || lingering > 0
+				if !dropletExists {
+					lingering--
+				}
+				if attached {
+					fmt.Fprint(rw, `{"volume": {"id": "vol", "droplet_ids": [1]}}`)
+					return
+				}
+				fmt.Fprint(rw, `{"volume": {"id": "vol", "droplet_ids": []}}`)
+			case "POST /v2/volumes/vol/actions":
+				// The droplet is gone by the time the volume is
+				// destroyed, so there's nothing to detach from.
+				notFound(rw)
+			case "DELETE /v2/volumes/vol":
+				if dropletExists || lingering > 0 {
+					t.Errorf("%s: volume deleted while attached", tc.desc)
+				}
+				volumeExists = false
+				rw.WriteHeader(http.StatusNoContent)
+			case "DELETE /v2/account/keys/5":
+				rw.WriteHeader(http.StatusNoContent)
+			default:
+				t.Errorf("%s: unexpected request %s %s", tc.desc, req.Method, req.URL.Path)
+				notFound(rw)
+			}
+		})
+
+		c := &Core{DropletID: 1, VolumeID: "vol", SSHKeyIDs: []int{5}}
+		err := destroy(context.Background(), client, c)
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: destroy: %s", tc.desc, err)
+			continue
+		}
+
+		want := []string{"DELETE /v2/droplets/1"}
+		if tc.lingering > 0 {
+			want = append(want, "POST /v2/volumes/vol/actions")
+		}
+		want = append(want, "DELETE /v2/volumes/vol", "DELETE /v2/account/keys/5")
+		if !reflect.DeepEqual(changes, want) {
+			t.Errorf("%s: got requests %v, want %v", tc.desc, changes, want)
+		}
+	}
+}
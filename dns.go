@@ -0,0 +1,215 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// dnsTTL is the TTL, in seconds, of the DNS records created for a
+// Core. It's short so that a redeploy takes effect quickly.
+const dnsTTL = 60
+
+// DNSName makes Deploy point subdomain.domain at the Core by creating
+// an A record, and an AAAA record if the droplet has IPv6 and neither
+// a floating IP nor a load balancer. The domain must be managed by
+// DigitalOcean's DNS on the same account. Use an empty subdomain or
+// "@" for the domain's apex.
+//
+// Existing records with the same name are updated in place, so
+// redeploying a Core moves its DNS name to the new droplet, and an
+// existing AAAA record is deleted if the new Core doesn't get one. If
+// Deploy fails, these changes are rolled back. Destroy removes the
+// records if they still point at the Core.
+func DNSName(domain, subdomain string) Option {
+	return func(opt *options) {
+		opt.dnsDomain = domain
+		opt.dnsSubdomain = subdomain
+	}
+}
+
+// dnsName returns the fully qualified name of the subdomain.
+func dnsName(domain, subdomain string) string {
+	if subdomain == "" || subdomain == "@" {
+		return domain
+	}
+	return subdomain + "." + domain
+}
+
+// WaitForDNS waits until the Core's DNS name resolves to its address.
+// It returns immediately if the Core has no DNS name.
+func WaitForDNS(ctx context.Context, c *Core) error {
+	if c.DNSName == "" {
+		return nil
+	}
+	for {
+		addrs, _ := net.DefaultResolver.LookupHost(ctx, c.DNSName)
+		for _, addr := range addrs {
+			if addr == c.entryIP() {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// validateDNS checks that the DNS domain is managed by the account,
+// recording any problems in verr.
+func validateDNS(ctx context.Context, client *godo.Client, opt *options, verr *ValidationError) error {
+	if opt.dnsDomain == "" {
+		return nil
+	}
+	_, _, err := client.Domains.Get(ctx, opt.dnsDomain)
+	if isNotFound(err) {
+		verr.addf("domain %s is not managed by this DigitalOcean account", opt.dnsDomain)
+		return nil
+	}
+	return err
+}
+
+// setupDNS creates or updates the Core's DNS records if a DNS name
+// was requested, appending any changes it makes to created so that
+// they can be rolled back. An AAAA record left over from a previous
+// Core is deleted if the new Core doesn't get one.
+func setupDNS(ctx context.Context, client *godo.Client, core *Core, opt *options, created *[]createdResource) error {
+	if opt.dnsDomain == "" {
+		return nil
+	}
+	name := opt.dnsSubdomain
+	if name == "" {
+		name = "@"
+	}
+
+	records := []godo.DomainRecordEditRequest{
+		{Type: "A", Name: name, Data: core.entryIP(), TTL: dnsTTL},
+	}
+	wantAAAA := core.IPv6Address != "" && core.FloatingIP == "" && core.LoadBalancerIP == ""
+	if wantAAAA {
+		records = append(records, godo.DomainRecordEditRequest{
+			Type: "AAAA", Name: name, Data: core.IPv6Address, TTL: dnsTTL,
+		})
+	}
+
+	existing, err := listDomainRecords(ctx, client, opt.dnsDomain)
+	if err != nil {
+		return err
+	}
+
+	core.DNSDomain = opt.dnsDomain
+	core.DNSName = dnsName(opt.dnsDomain, opt.dnsSubdomain)
+	for i := range records {
+		req := &records[i]
+
+		found := findDomainRecord(existing, req.Type, req.Name)
+		if found != nil {
+			if found.Data != req.Data {
+				_, _, err = client.Domains.EditRecord(ctx, opt.dnsDomain, found.ID, req)
+				if err != nil {
+					return err
+				}
+				old := godo.DomainRecordEditRequest{
+					Type: found.Type, Name: found.Name, Data: found.Data, TTL: found.TTL,
+				}
+				recordID := found.ID
+				*created = append(*created, createdResource{
+					name: fmt.Sprintf("change to %s record %d", req.Type, recordID),
+					destroy: func(ctx context.Context) error {
+						_, _, err := client.Domains.EditRecord(ctx, opt.dnsDomain, recordID, &old)
+						return err
+					},
+				})
+			}
+			core.DNSRecordIDs = append(core.DNSRecordIDs, found.ID)
+			continue
+		}
+
+		record, _, err := client.Domains.CreateRecord(ctx, opt.dnsDomain, req)
+		if err != nil {
+			return err
+		}
+		recordID := record.ID
+		*created = append(*created, createdResource{
+			name: fmt.Sprintf("%s record %d", req.Type, recordID),
+			destroy: func(ctx context.Context) error {
+				_, err := client.Domains.DeleteRecord(ctx, opt.dnsDomain, recordID)
+				if isNotFound(err) {
+					return nil
+				}
+				return err
+			},
+		})
+		core.Created = append(core.Created, fmt.Sprintf("%s record %s", req.Type, core.DNSName))
+		core.DNSRecordIDs = append(core.DNSRecordIDs, recordID)
+	}
+
+	if stale := findDomainRecord(existing, "AAAA", name); stale != nil && !wantAAAA {
+		_, err = client.Domains.DeleteRecord(ctx, opt.dnsDomain, stale.ID)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		old := godo.DomainRecordEditRequest{
+			Type: stale.Type, Name: stale.Name, Data: stale.Data, TTL: stale.TTL,
+		}
+		*created = append(*created, createdResource{
+			name: fmt.Sprintf("deletion of AAAA record %d", stale.ID),
+			destroy: func(ctx context.Context) error {
+				_, _, err := client.Domains.CreateRecord(ctx, opt.dnsDomain, &old)
+				return err
+			},
+		})
+	}
+	return nil
+}
+
+// findDomainRecord returns the record with the provided type and name,
+// or nil if there isn't one.
+func findDomainRecord(records []godo.DomainRecord, typ, name string) *godo.DomainRecord {
+	var found *godo.DomainRecord
+	for i := range records {
+		if records[i].Type == typ && records[i].Name == name {
+			found = &records[i]
+		}
+	}
+	return found
+}
+
+// deleteDNSRecords deletes the Core's DNS records, skipping any that
+// have since been pointed elsewhere, such as at a replacement Core.
+func deleteDNSRecords(ctx context.Context, client *godo.Client, c *Core) error {
+	for _, id := range c.DNSRecordIDs {
+		record, _, err := client.Domains.Record(ctx, c.DNSDomain, id)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if record.Data != c.entryIP() && record.Data != c.IPv6Address {
+			continue
+		}
+		_, err = client.Domains.DeleteRecord(ctx, c.DNSDomain, id)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDomainRecords returns all of the domain's records, following
+// pagination.
+func listDomainRecords(ctx context.Context, client *godo.Client, domain string) ([]godo.DomainRecord, error) {
+	var all []godo.DomainRecord
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		records, resp, err := client.Domains.Records(ctx, domain, opt)
+		all = append(all, records...)
+		return resp, err
+	})
+	return all, err
+}
@@ -0,0 +1,180 @@
+package dochaincore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+// testZone is an in-memory DigitalOcean DNS domain served over the
+// domain records API.
+type testZone struct {
+	records map[int]godo.DomainRecord
+	nextID  int
+}
+
+func newTestZone(records ...godo.DomainRecord) *testZone {
+	z := &testZone{records: make(map[int]godo.DomainRecord), nextID: 100}
+	for _, r := range records {
+		z.records[r.ID] = r
+	}
+	return z
+}
+
+func (z *testZone) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	const prefix = "/v2/domains/example.com/records"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(rw, req)
+		return
+	}
+	if req.URL.Path == prefix {
+		switch req.Method {
+		case "GET":
+			var all []godo.DomainRecord
+			for _, r := range z.records {
+				all = append(all, r)
+			}
+			json.NewEncoder(rw).Encode(map[string]interface{}{"domain_records": all})
+		case "POST":
+			var edit godo.DomainRecordEditRequest
+			json.NewDecoder(req.Body).Decode(&edit)
+			z.nextID++
+			r := godo.DomainRecord{ID: z.nextID, Type: edit.Type, Name: edit.Name, Data: edit.Data, TTL: edit.TTL}
+			z.records[r.ID] = r
+			rw.WriteHeader(http.StatusCreated)
+			json.NewEncoder(rw).Encode(map[string]interface{}{"domain_record": r})
+		}
+		return
+	}
+
+	id, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path, prefix+"/"))
+	r, ok := z.records[id]
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(rw, `{"id": "not_found", "message": "not found"}`)
+		return
+	}
+	switch req.Method {
+	case "GET":
+		json.NewEncoder(rw).Encode(map[string]interface{}{"domain_record": r})
+	case "PUT":
+		var edit godo.DomainRecordEditRequest
+		json.NewDecoder(req.Body).Decode(&edit)
+		r.Data, r.TTL = edit.Data, edit.TTL
+		z.records[id] = r
+		json.NewEncoder(rw).Encode(map[string]interface{}{"domain_record": r})
+	case "DELETE":
+		delete(z.records, id)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// contents returns the zone's records as sorted "type name data"
+// strings.
+func (z *testZone) contents() []string {
+	var all []string
+	for _, r := range z.records {
+		all = append(all, fmt.Sprintf("%s %s %s", r.Type, r.Name, r.Data))
+	}
+	sort.Strings(all)
+	return all
+}
+
+func TestSetupDNS(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		existing []godo.DomainRecord
+		core     *Core
+		want     []string
+	}{{
+		desc: "new records",
+		core: &Core{IPv4Address: "10.0.0.1", IPv6Address: "fd00::1"},
+		want: []string{"A core 10.0.0.1", "AAAA core fd00::1"},
+	}, {
+		desc: "redeploy",
+		existing: []godo.DomainRecord{
+			{ID: 1, Type: "A", Name: "core", Data: "10.0.0.9", TTL: 300},
+			{ID: 2, Type: "AAAA", Name: "core", Data: "fd00::9", TTL: 300},
+			{ID: 3, Type: "A", Name: "www", Data: "10.0.0.8"},
+		},
+		core: &Core{IPv4Address: "10.0.0.1", IPv6Address: "fd00::1"},
+		want: []string{"A core 10.0.0.1", "A www 10.0.0.8", "AAAA core fd00::1"},
+	}, {
+		desc: "redeploy with floating IP",
+		existing: []godo.DomainRecord{
+			{ID: 1, Type: "A", Name: "core", Data: "10.0.0.9", TTL: 300},
+			{ID: 2, Type: "AAAA", Name: "core", Data: "fd00::9", TTL: 300},
+		},
+		core: &Core{IPv4Address: "10.0.0.1", IPv6Address: "fd00::1", FloatingIP: "10.1.0.1"},
+		want: []string{"A core 10.1.0.1"},
+	}, {
+		desc: "redeploy with load balancer",
+		existing: []godo.DomainRecord{
+			{ID: 2, Type: "AAAA", Name: "core", Data: "fd00::9", TTL: 300},
+		},
+		core: &Core{IPv4Address: "10.0.0.1", IPv6Address: "fd00::1", LoadBalancerIP: "10.2.0.1"},
+		want: []string{"A core 10.2.0.1"},
+	}}
+	for _, tc := range testCases {
+		zone := newTestZone(tc.existing...)
+		before := zone.contents()
+		client, srv := newTestClient(zone.ServeHTTP)
+
+		ctx := context.Background()
+		opt := buildOptions([]Option{DNSName("example.com", "core")})
+		var created []createdResource
+		err := setupDNS(ctx, client, tc.core, &opt, &created)
+		if err != nil {
+			t.Errorf("%s: setupDNS: %s", tc.desc, err)
+		} else if got := zone.contents(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got records %v, want %v", tc.desc, got, tc.want)
+		}
+
+		// Rolling back must leave the zone as it was.
+		for i := len(created) - 1; i >= 0; i-- {
+			err = created[i].destroy(ctx)
+			if err != nil {
+				t.Errorf("%s: rolling back %s: %s", tc.desc, created[i].name, err)
+			}
+		}
+		if got := zone.contents(); !reflect.DeepEqual(got, before) {
+			t.Errorf("%s: after rollback got records %v, want %v", tc.desc, got, before)
+		}
+		srv.Close()
+	}
+}
+
+func TestDeleteDNSRecords(t *testing.T) {
+	zone := newTestZone(
+		godo.DomainRecord{ID: 1, Type: "A", Name: "core", Data: "10.0.0.1"},
+		godo.DomainRecord{ID: 2, Type: "AAAA", Name: "core", Data: "fd00::1"},
+		// Pointed at a replacement Core since this one was deployed.
+		godo.DomainRecord{ID: 3, Type: "A", Name: "api", Data: "10.0.0.2"},
+		godo.DomainRecord{ID: 4, Type: "A", Name: "www", Data: "10.0.0.1"},
+	)
+	client, srv := newTestClient(zone.ServeHTTP)
+	defer srv.Close()
+
+	c := &Core{
+		IPv4Address:  "10.0.0.1",
+		IPv6Address:  "fd00::1",
+		DNSDomain:    "example.com",
+		DNSRecordIDs: []int{1, 2, 3, 99},
+	}
+	err := deleteDNSRecords(context.Background(), client, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A api 10.0.0.2", "A www 10.0.0.1"}
+	if got := zone.contents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package dochaincore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// authorizedKeysFiles is a shell glob of the files on the droplet
+// that Finalize removes the deployer key from.
+var authorizedKeysFiles = "/root/.ssh/authorized_keys /home/*/.ssh/authorized_keys"
+
+// Finalize removes the deployer's SSH key from the droplet once
+// provisioning is done, so that the key no longer grants root access.
+// It verifies the removal by attempting a new connection with the key,
+// then zeroes the key material held by c.
+//
+// Operations that need SSH, such as Backup and CreateClientToken,
+// fail on a finalized Core. Calling Finalize again is a no-op.
+func Finalize(ctx context.Context, c *Core) error {
+	if c.Finalized {
+		return nil
+	}
+	if c.ssh == nil {
+		return errors.New("Core has no SSH key; load it with LoadCore")
+	}
+
+	// The key's base64 blob identifies its line in authorized_keys
+	// regardless of the options or comment around it. Base64 never
+	// contains '#', so it's safe as the sed delimiter.
+	fields := strings.Fields(string(c.ssh.authorizedKey))
+	if len(fields) < 2 {
+		return errors.New("malformed deployer key")
+	}
+	cmd := fmt.Sprintf(`for f in %s; do [ -f "$f" ] && sed -i '\#%s#d' "$f"; done; true`, authorizedKeysFiles, fields[1])
+	_, err := c.Run(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	// The existing connection stays authenticated, so verify with a
+	// new one.
+	client, err := dial(ctx, c)
+	if err == nil {
+		client.Close()
+		return errors.New("deployer key is still authorized after removing it")
+	}
+	if !isAuthError(err) {
+		return fmt.Errorf("verifying deployer key removal: %s", err)
+	}
+
+	c.Close()
+	c.ssh.zero()
+	c.ssh = nil
+	c.Finalized = true
+	return nil
+}
+
+// isAuthError returns true if err is an SSH client error indicating
+// that the server rejected every authentication method. The ssh
+// package doesn't export a type for it.
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "ssh: unable to authenticate")
+}
@@ -0,0 +1,108 @@
+package dochaincore
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFinalizeServer starts a test SSH server that authorizes the keys
+// in a temporary authorized_keys file, which Finalize edits in place
+// of the droplet's. The file also lists another key, which Finalize
+// must leave alone.
+func testFinalizeServer(t *testing.T) (c *Core, srv *testSSHServer, path string, stop func()) {
+	dir, err := ioutil.TempDir("", "dochaincore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "authorized_keys")
+	other, err := createSSHKeyPair(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv = &testSSHServer{authorizedKeys: path}
+	c, stopServer := startTestSSHServer(t, srv)
+	err = ioutil.WriteFile(path, append(c.ssh.authorizedKey, other.authorizedKey...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFiles := authorizedKeysFiles
+	authorizedKeysFiles = path
+	return c, srv, path, func() {
+		stopServer()
+		authorizedKeysFiles = oldFiles
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	c, _, path, stop := testFinalizeServer(t)
+	defer stop()
+
+	keypair := c.ssh
+	deployerKey := strings.TrimSpace(string(keypair.authorizedKey))
+	err := Finalize(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Finalized || c.ssh != nil {
+		t.Errorf("got Finalized %t with SSH key %v, want a finalized Core without a key", c.Finalized, c.ssh)
+	}
+	for _, b := range keypair.privateKey.(ed25519.PrivateKey) {
+		if b != 0 {
+			t.Error("deployer private key wasn't zeroed")
+			break
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), deployerKey) {
+		t.Errorf("deployer key still in authorized_keys:\n%s", b)
+	}
+	if strings.Count(string(b), "\n") != 1 {
+		t.Errorf("Finalize removed other keys from authorized_keys:\n%s", b)
+	}
+
+	err = Finalize(context.Background(), c)
+	if err != nil {
+		t.Errorf("second Finalize: %s", err)
+	}
+}
+
+func TestFinalizeDialError(t *testing.T) {
+	c, srv, _, stop := testFinalizeServer(t)
+	defer stop()
+	ctx := context.Background()
+
+	// Connect before the server starts dropping connections, so that
+	// only Finalize's verification fails, and not with an
+	// authentication error.
+	_, err := c.Run(ctx, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.setDropConns(true)
+
+	keypair := c.ssh
+	err = Finalize(ctx, c)
+	if err == nil {
+		t.Fatal("Finalize succeeded without verifying the key's removal")
+	}
+	if c.Finalized || c.ssh != keypair {
+		t.Errorf("got Finalized %t with SSH key %v, want the Core unchanged", c.Finalized, c.ssh)
+	}
+	for _, b := range keypair.privateKey.(ed25519.PrivateKey) {
+		if b != 0 {
+			return
+		}
+	}
+	t.Error("deployer private key was zeroed")
+}
@@ -0,0 +1,156 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// egressIPURL is a service that responds with the caller's public
+// IPv4 address.
+var egressIPURL = "https://api.ipify.org"
+
+// AllowedCIDRs restricts access to Chain Core to the provided CIDR
+// ranges or IP addresses by configuring the droplet's firewall. By
+// default Chain Core is reachable from anywhere. It can't be used
+// with LoadBalancer.
+func AllowedCIDRs(cidrs ...string) Option {
+	return func(opt *options) { opt.allowedCIDRs = append(opt.allowedCIDRs, cidrs...) }
+}
+
+// AllowSSHFrom restricts SSH access to the droplet to the provided
+// CIDR ranges or IP addresses by configuring the droplet's firewall.
+// By default SSH is reachable from anywhere.
+func AllowSSHFrom(cidrs ...string) Option {
+	return func(opt *options) { opt.sshCIDRs = append(opt.sshCIDRs, cidrs...) }
+}
+
+// AllowDeployer adds the public IPv4 address of the machine running
+// Deploy to any restrictions set by AllowedCIDRs and AllowSSHFrom, so
+// that WaitForSSH, WaitForHTTP and CreateClientToken can still reach
+// the droplet. Deploy looks up the address using an external service.
+func AllowDeployer() Option {
+	return func(opt *options) { opt.allowDeployer = true }
+}
+
+// firewall describes the droplet's firewall rules. An empty list
+// allows access from anywhere.
+type firewall struct {
+	SSHFrom  []string
+	HTTPFrom []string
+}
+
+// HTTPFromIPv4 returns the IPv4 ranges allowed to reach Chain Core.
+func (f *firewall) HTTPFromIPv4() []string {
+	var cidrs []string
+	for _, cidr := range f.HTTPFrom {
+		if !strings.Contains(cidr, ":") {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+// validateFirewall checks the firewall options, recording any
+// problems in verr.
+func validateFirewall(opt *options, verr *ValidationError) {
+	if len(opt.allowedCIDRs) > 0 && opt.lbCertificateID != "" {
+		verr.addf("AllowedCIDRs can't be used with LoadBalancer")
+	}
+	for _, cidr := range append(opt.allowedCIDRs, opt.sshCIDRs...) {
+		if _, err := normalizeCIDR(cidr); err != nil {
+			verr.addf("invalid CIDR %q", cidr)
+		}
+	}
+}
+
+// buildFirewall returns the droplet's firewall rules, or nil if
+// access isn't restricted.
+func buildFirewall(ctx context.Context, opt *options) (*firewall, error) {
+	if len(opt.allowedCIDRs) == 0 && len(opt.sshCIDRs) == 0 {
+		return nil, nil
+	}
+
+	fw := new(firewall)
+	var err error
+	fw.SSHFrom, err = normalizeCIDRs(opt.sshCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	fw.HTTPFrom, err = normalizeCIDRs(opt.allowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.allowDeployer {
+		ip, err := egressIP(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("looking up deployer IP: %s", err)
+		}
+		deployer := ip + "/32"
+		if len(fw.SSHFrom) > 0 {
+			fw.SSHFrom = append(fw.SSHFrom, deployer)
+		}
+		if len(fw.HTTPFrom) > 0 {
+			fw.HTTPFrom = append(fw.HTTPFrom, deployer)
+		}
+	}
+	return fw, nil
+}
+
+func normalizeCIDRs(cidrs []string) ([]string, error) {
+	var normalized []string
+	for _, cidr := range cidrs {
+		n, err := normalizeCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, n)
+	}
+	return normalized, nil
+}
+
+// normalizeCIDR parses a CIDR range or a single IP address, returning
+// it in CIDR notation.
+func normalizeCIDR(s string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", err
+	}
+	return ipnet.String(), nil
+}
+
+// egressIP returns the public IPv4 address of this machine.
+func egressIP(ctx context.Context) (string, error) {
+	req, err := http.NewRequest("GET", egressIPURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s responded %s", egressIPURL, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b))).To4()
+	if ip == nil {
+		return "", fmt.Errorf("%s responded with %q, not an IPv4 address", egressIPURL, b)
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,159 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+// FloatingIP assigns the existing floating IP to the droplet so that
+// the Core keeps a stable address across rebuilds. The floating IP
+// must be in the droplet's region.
+func FloatingIP(ip string) Option {
+	return func(opt *options) { opt.floatingIP = ip }
+}
+
+// AllocateFloatingIP allocates a new floating IP in the droplet's
+// region and assigns it to the droplet. Destroy releases it unless it
+// has since been reassigned to another droplet. With Idempotent, a
+// floating IP already assigned to an adopted droplet is reused.
+func AllocateFloatingIP() Option {
+	return func(opt *options) { opt.allocateFloatingIP = true }
+}
+
+// ReassignFloatingIP moves the floating IP to the provided Core, for
+// example to point a stable address at a replacement Core restored
+// from a backup. It records the floating IP on c.
+func ReassignFloatingIP(ctx context.Context, accessToken string, ip string, c *Core) error {
+	client := newClient(ctx, accessToken)
+	err := assignFloatingIP(ctx, client, ip, c.DropletID)
+	if err != nil {
+		return err
+	}
+	c.FloatingIP = ip
+	return nil
+}
+
+// validateFloatingIP checks that the floating IP exists and is in the
+// droplet's region, recording any problems in verr.
+func validateFloatingIP(ctx context.Context, client *godo.Client, opt *options, verr *ValidationError) error {
+	if opt.floatingIP != "" && opt.allocateFloatingIP {
+		verr.addf("FloatingIP and AllocateFloatingIP can't be used together")
+	}
+	if opt.floatingIP == "" {
+		return nil
+	}
+
+	fip, _, err := client.FloatingIPs.Get(ctx, opt.floatingIP)
+	switch {
+	case isNotFound(err):
+		verr.addf("floating IP %s does not exist", opt.floatingIP)
+	case err != nil:
+		return err
+	case fip.Region == nil || fip.Region.Slug != opt.dropletRegion:
+		verr.addf("floating IP %s is not in region %s", opt.floatingIP, opt.dropletRegion)
+	}
+	return nil
+}
+
+// setupFloatingIP allocates and assigns the Core's floating IP if one
+// was requested, appending any allocated IP to created.
+func setupFloatingIP(ctx context.Context, client *godo.Client, core *Core, opt *options, created *[]createdResource) error {
+	ip := opt.floatingIP
+	if opt.allocateFloatingIP {
+		// An adopted droplet may already have the floating IP
+		// that an earlier run allocated.
+		if opt.idempotent {
+			existing, err := findFloatingIP(ctx, client, core.DropletID)
+			if err != nil {
+				return err
+			}
+			if existing != "" {
+				core.Adopted = append(core.Adopted, fmt.Sprintf("floating IP %s", existing))
+				core.FloatingIP = existing
+				return nil
+			}
+		}
+
+		fip, _, err := client.FloatingIPs.Create(ctx, &godo.FloatingIPCreateRequest{
+			Region: opt.dropletRegion,
+		})
+		if err != nil {
+			return err
+		}
+		ip = fip.IP
+		*created = append(*created, createdResource{
+			name:    fmt.Sprintf("floating IP %s", ip),
+			destroy: func(ctx context.Context) error { return releaseFloatingIP(ctx, client, ip, core.DropletID) },
+		})
+		core.Created = append(core.Created, fmt.Sprintf("floating IP %s", ip))
+		core.AllocatedFloatingIP = true
+	}
+	if ip == "" {
+		return nil
+	}
+
+	err := assignFloatingIP(ctx, client, ip, core.DropletID)
+	if err != nil {
+		return err
+	}
+	core.FloatingIP = ip
+	return nil
+}
+
+// findFloatingIP returns the floating IP assigned to the droplet, or
+// "" if it has none.
+func findFloatingIP(ctx context.Context, client *godo.Client, dropletID int) (string, error) {
+	var ip string
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		fips, resp, err := client.FloatingIPs.List(ctx, opt)
+		for _, fip := range fips {
+			if fip.Droplet != nil && fip.Droplet.ID == dropletID {
+				ip = fip.IP
+			}
+		}
+		return resp, err
+	})
+	return ip, err
+}
+
+// assignFloatingIP assigns the floating IP to the droplet, unless
+// it's already assigned to it, and waits for the assignment.
+func assignFloatingIP(ctx context.Context, client *godo.Client, ip string, dropletID int) error {
+	fip, _, err := client.FloatingIPs.Get(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if fip.Droplet != nil && fip.Droplet.ID == dropletID {
+		return nil
+	}
+
+	action, _, err := client.FloatingIPActions.Assign(ctx, ip, dropletID)
+	if err != nil {
+		return err
+	}
+	return waitForAction(ctx, client, action)
+}
+
+// releaseFloatingIP deletes the floating IP if it's unassigned or
+// still assigned to the droplet. A floating IP that was reassigned to
+// another droplet is left alone.
+func releaseFloatingIP(ctx context.Context, client *godo.Client, ip string, dropletID int) error {
+	fip, _, err := client.FloatingIPs.Get(ctx, ip)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fip.Droplet != nil && fip.Droplet.ID != dropletID {
+		return nil
+	}
+
+	_, err = client.FloatingIPs.Delete(ctx, ip)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
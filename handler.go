@@ -28,7 +28,7 @@ const indexPageHTML = `<!DOCTYPE html>
 			<div id="header">
 				<a href="https://chain.com"><img src="https://chain.com/docs/1.1/images/chain-brand.png" alt="Chain" class="mainsite" /></a>
 			</div>
-			<p>Install <a href="https://chain.com">Chain Core</a> on a DigitalOcean droplet. This installer creates a new 1gb droplet and a 100gb block storage volume on your DigitalOcean account. It installs Chain Core on the droplet using the attached volume for storage. The approximate cost on DigitalOcean is $20/month.</p>
+			<p>Install <a href="https://chain.com">Chain Core</a> on a DigitalOcean droplet. This installer creates a new {{.Cost.DropletSize}} droplet and a {{.Cost.VolumeSizeGB}}gb block storage volume on your DigitalOcean account. It installs Chain Core on the droplet using the attached volume for storage.{{if .Cost.TotalMonthly}} The approximate cost on DigitalOcean is ${{printf "%.2f" .Cost.TotalMonthly}}/month.{{end}}</p>
 			<a href="{{.InstallLink}}" class="btn-success" id="install-btn">Install Chain Core</a>
   		</div>
 	</body>
@@ -56,12 +56,17 @@ const progressPageHTML = `
 			<p id="status-line">Initializing droplet&hellip;</p>
 			<div id="core-info">
 				<p>Success! Chain Core has been installed on your DigitalOcean droplet. To access
-				Chain Core's API and Dashboard, you'll need your client token:</p>
+				Chain Core's API and Dashboard, you'll need your client token. The dashboard
+				will ask for it when you open it.</p>
 				<div class="coredata">
 					<div><strong>URL:</strong> <code id="core-url"></code></div>
 					<div><strong>Token:</strong> <code id="client-token"></code></div>
 				</div>
+				<p id="core-warning"></p>
 				<a href="http://:1999/dashboard" target="_blank" class="btn-success" id="open-dashboard">Open dashboard</a>
+				<p>The installer serves Chain Core over plain HTTP, so your client token is
+				sent unencrypted. To serve it over HTTPS, deploy with the <code>dochaincore</code>
+				command-line tool's <code>-https</code> or <code>-load-balancer-cert</code> flags.</p>
 				<p>When destroying the droplet, remember to also destroy its block storage volume.</p>
 			</div>
 		</div>
@@ -69,7 +74,17 @@ const progressPageHTML = `
 </html>
 `
 
-func Handler(oauthClientID, oauthClientSecret, host string) http.Handler {
+// HandlerOption configures the web installer returned by Handler.
+type HandlerOption func(*handler)
+
+// PricingToken provides a DigitalOcean access token that the installer
+// uses to look up current prices for the cost estimate on its index
+// page. Without it, the index page omits the estimate.
+func PricingToken(accessToken string) HandlerOption {
+	return func(h *handler) { h.pricingToken = accessToken }
+}
+
+func Handler(oauthClientID, oauthClientSecret, host string, opts ...HandlerOption) http.Handler {
 	h := &handler{
 		oauthClientID:     oauthClientID,
 		oauthClientSecret: oauthClientSecret,
@@ -78,6 +93,9 @@ func Handler(oauthClientID, oauthClientSecret, host string) http.Handler {
 		indexTmpl:         template.Must(template.New("index").Parse(indexPageHTML)),
 		installs:          make(map[string]*install),
 	}
+	for _, o := range opts {
+		o(h)
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status/", h.status)
 	mux.HandleFunc("/grant", h.grant)
@@ -96,6 +114,57 @@ type handler struct {
 
 	installMu sync.Mutex
 	installs  map[string]*install
+
+	pricingToken   string
+	costMu         sync.Mutex
+	cost           *CostEstimate
+	costExpires    time.Time
+	costRefreshing bool
+}
+
+// How long a cost estimate is cached, and how long to wait before
+// retrying after prices couldn't be fetched.
+const (
+	costTTL      = time.Hour
+	costRetryTTL = 5 * time.Minute
+)
+
+// costEstimate returns the cached estimated monthly cost of an install
+// without blocking, starting a refresh in the background if the cache
+// has expired. Until prices have been fetched, the estimate's prices
+// are zero.
+func (h *handler) costEstimate() *CostEstimate {
+	h.costMu.Lock()
+	defer h.costMu.Unlock()
+	if h.pricingToken != "" && !h.costRefreshing && !time.Now().Before(h.costExpires) {
+		h.costRefreshing = true
+		go h.refreshCost()
+	}
+	if h.cost != nil {
+		return h.cost
+	}
+	opt := buildOptions(nil)
+	return &CostEstimate{DropletSize: opt.dropletSize, VolumeSizeGB: opt.volumeSize}
+}
+
+// refreshCost fetches current prices and caches the cost estimate. If
+// prices can't be fetched, the previous estimate is kept and the
+// refresh is retried after costRetryTTL.
+func (h *handler) refreshCost() {
+	opt := buildOptions(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cost, err := estimateCost(ctx, newClient(ctx, h.pricingToken), &opt)
+
+	h.costMu.Lock()
+	defer h.costMu.Unlock()
+	h.costRefreshing = false
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "estimating cost: %s\n", err)
+		h.costExpires = time.Now().Add(costRetryTTL)
+		return
+	}
+	h.cost, h.costExpires = cost, time.Now().Add(costTTL)
 }
 
 func (h *handler) index(rw http.ResponseWriter, req *http.Request) {
@@ -126,8 +195,10 @@ func (h *handler) index(rw http.ResponseWriter, req *http.Request) {
 
 	h.indexTmpl.Execute(rw, struct {
 		InstallLink string
+		Cost        *CostEstimate
 	}{
 		InstallLink: u.String(),
+		Cost:        h.costEstimate(),
 	})
 }
 
@@ -248,6 +319,8 @@ type install struct {
 	Status      string `json:"status"`
 	ClientToken string `json:"client_token"`
 	IPAddress   string `json:"ip_address"`
+	URL         string `json:"url"`
+	Warning     string `json:"warning,omitempty"`
 	accessToken string
 	c           *Core
 }
@@ -280,9 +353,11 @@ func (i *install) init(state string) {
 	if err != nil {
 		return
 	}
+	defer core.Close()
 
 	i.mu.Lock()
-	i.IPAddress = core.IPv4Address
+	i.IPAddress = core.Host()
+	i.URL = core.URL()
 	i.c = core
 	i.Status = "waiting for ssh"
 	i.mu.Unlock()
@@ -305,8 +380,19 @@ func (i *install) init(state string) {
 	}
 
 	i.mu.Lock()
-	i.Status = "done"
 	i.ClientToken = token
+	i.Status = "finalizing"
+	i.mu.Unlock()
+
+	// The install is usable even if the deployer key can't be
+	// removed, so report that as a warning rather than a failure.
+	finalizeErr := Finalize(ctx, core)
+
+	i.mu.Lock()
+	i.Status = "done"
+	if finalizeErr != nil {
+		i.Warning = "The installer couldn't remove its SSH key from the droplet: " + finalizeErr.Error()
+	}
 	i.c = nil // garbage collect the SSH keys
 	i.mu.Unlock()
 }
@@ -0,0 +1,70 @@
+package dochaincore
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// defaultImage is the droplet image used when neither DropletImage
+// nor DropletImageID is provided. It should track the latest Ubuntu
+// LTS release available on DigitalOcean.
+const defaultImage = "ubuntu-24-04-x64"
+
+// DropletImage sets the slug of the distribution image to install on
+// the droplet, for example "ubuntu-24-04-x64". The image must provide
+// cloud-init and a docker.io package.
+func DropletImage(slug string) Option {
+	return func(opt *options) {
+		opt.dropletImage = godo.DropletCreateImage{Slug: slug}
+	}
+}
+
+// DropletImageID sets the ID of a custom image or snapshot to install
+// on the droplet.
+func DropletImageID(id int) Option {
+	return func(opt *options) {
+		opt.dropletImage = godo.DropletCreateImage{ID: id}
+	}
+}
+
+// imageName returns a human-readable name for the image: its slug if
+// it has one, otherwise its ID.
+func imageName(image godo.DropletCreateImage) string {
+	if image.Slug != "" {
+		return image.Slug
+	}
+	return strconv.Itoa(image.ID)
+}
+
+// validateImage checks that the image exists and is available in the
+// region, recording any problems in verr.
+func validateImage(ctx context.Context, client *godo.Client, image godo.DropletCreateImage, region string, verr *ValidationError) error {
+	var (
+		img *godo.Image
+		err error
+	)
+	if image.Slug != "" {
+		img, _, err = client.Images.GetBySlug(ctx, image.Slug)
+	} else {
+		img, _, err = client.Images.GetByID(ctx, image.ID)
+	}
+	if isNotFound(err) {
+		verr.addf("droplet image %q does not exist", imageName(image))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, r := range img.Regions {
+		if r == region {
+			return nil
+		}
+	}
+	verr.addf("droplet image %q is not available in region %s (available in: %s)",
+		imageName(image), region, strings.Join(img.Regions, ", "))
+	return nil
+}
@@ -0,0 +1,122 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHKeys limits the account SSH keys installed on the droplet to the
+// provided keys, each identified by its ID or fingerprint. By default
+// every SSH key on the account is installed.
+func SSHKeys(keys ...string) Option {
+	return func(opt *options) {
+		opt.accountKeys = append(opt.accountKeys, keys...)
+		opt.selectKeys = true
+	}
+}
+
+// NoAccountSSHKeys prevents Deploy from installing any of the
+// account's SSH keys on the droplet. Only the deployer key and keys
+// provided with UploadSSHKey are authorized.
+func NoAccountSSHKeys() Option {
+	return func(opt *options) {
+		opt.accountKeys = nil
+		opt.selectKeys = true
+	}
+}
+
+// UploadSSHKey registers the provided public key, in authorized_keys
+// format, on the account under name and installs it on the droplet.
+// If the key is already registered, the existing key is used. Keys
+// registered by Deploy are recorded in Core.SSHKeyIDs and removed by
+// Destroy.
+func UploadSSHKey(name, publicKey string) Option {
+	return func(opt *options) {
+		opt.uploadKeys = append(opt.uploadKeys, godo.KeyCreateRequest{
+			Name:      name,
+			PublicKey: publicKey,
+		})
+	}
+}
+
+// dropletSSHKeys returns the SSH keys to install on the droplet,
+// registering the keys provided with UploadSSHKey first. It returns
+// the IDs of the keys it registered, appending them to created; keys
+// that were already registered aren't included.
+func dropletSSHKeys(ctx context.Context, client *godo.Client, opt *options, created *[]createdResource) ([]godo.DropletCreateSSHKey, []int, error) {
+	keys, err := accountSSHKeys(ctx, client, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	var registered []int
+	for _, req := range opt.uploadKeys {
+		key, isNew, err := uploadSSHKey(ctx, client, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isNew {
+			keyID := key.ID
+			registered = append(registered, keyID)
+			*created = append(*created, createdResource{
+				name:    fmt.Sprintf("ssh key %d", keyID),
+				destroy: func(ctx context.Context) error { return destroySSHKey(ctx, client, keyID) },
+			})
+		}
+		keys = append(keys, godo.DropletCreateSSHKey{ID: key.ID})
+	}
+	return keys, registered, nil
+}
+
+// accountSSHKeys returns the account SSH keys to install on the
+// droplet.
+func accountSSHKeys(ctx context.Context, client *godo.Client, opt *options) ([]godo.DropletCreateSSHKey, error) {
+	var keys []godo.DropletCreateSSHKey
+	if opt.selectKeys {
+		for _, k := range opt.accountKeys {
+			if id, err := strconv.Atoi(k); err == nil {
+				keys = append(keys, godo.DropletCreateSSHKey{ID: id})
+			} else {
+				keys = append(keys, godo.DropletCreateSSHKey{Fingerprint: k})
+			}
+		}
+		return keys, nil
+	}
+
+	err := paginate(func(listOpt *godo.ListOptions) (*godo.Response, error) {
+		page, resp, err := client.Keys.List(ctx, listOpt)
+		for _, key := range page {
+			keys = append(keys, godo.DropletCreateSSHKey{ID: key.ID})
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// uploadSSHKey registers the public key on the account. If the key is
+// already registered, it returns the existing key and false.
+func uploadSSHKey(ctx context.Context, client *godo.Client, req godo.KeyCreateRequest) (*godo.Key, bool, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing SSH public key %q: %s", req.Name, err)
+	}
+	key, _, err := client.Keys.GetByFingerprint(ctx, ssh.FingerprintLegacyMD5(publicKey))
+	if err == nil {
+		return key, false, nil
+	}
+	if !isNotFound(err) {
+		return nil, false, err
+	}
+
+	key, _, err = client.Keys.Create(ctx, &req)
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
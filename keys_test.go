@@ -0,0 +1,104 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDropletSSHKeys(t *testing.T) {
+	registered, err := createSSHKeyPair(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unregistered, err := createSSHKeyPair(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(registered.authorizedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registeredFingerprint := ssh.FingerprintLegacyMD5(pub)
+
+	testCases := []struct {
+		desc           string
+		opts           []Option
+		want           []godo.DropletCreateSSHKey
+		wantRegistered []int
+		wantRequests   int
+	}{{
+		desc:         "all account keys",
+		want:         []godo.DropletCreateSSHKey{{ID: 1}, {ID: 2}, {ID: 3}},
+		wantRequests: 2,
+	}, {
+		desc: "selected keys",
+		opts: []Option{SSHKeys("123", "aa:bb:cc")},
+		want: []godo.DropletCreateSSHKey{{ID: 123}, {Fingerprint: "aa:bb:cc"}},
+	}, {
+		desc: "no account keys",
+		opts: []Option{NoAccountSSHKeys()},
+	}, {
+		desc: "uploaded keys",
+		opts: []Option{
+			NoAccountSSHKeys(),
+			UploadSSHKey("laptop", string(registered.authorizedKey)),
+			UploadSSHKey("ci", string(unregistered.authorizedKey)),
+		},
+		want:           []godo.DropletCreateSSHKey{{ID: 50}, {ID: 60}},
+		wantRegistered: []int{60},
+		wantRequests:   3,
+	}}
+	for _, tc := range testCases {
+		var requests int
+		client, srv := newTestClient(func(rw http.ResponseWriter, req *http.Request) {
+			requests++
+			switch {
+			case req.Method == "GET" && req.URL.Path == "/v2/account/keys":
+				// Two pages of keys.
+				if req.URL.Query().Get("page") == "2" {
+					fmt.Fprint(rw, `{"ssh_keys": [{"id": 3}], "links": {"pages": {"prev": "http://api/v2/account/keys?page=1"}}}`)
+					return
+				}
+				fmt.Fprint(rw, `{"ssh_keys": [{"id": 1}, {"id": 2}], "links": {"pages": {"next": "http://api/v2/account/keys?page=2", "last": "http://api/v2/account/keys?page=2"}}}`)
+			case req.Method == "GET" && req.URL.Path == "/v2/account/keys/"+registeredFingerprint:
+				fmt.Fprint(rw, `{"ssh_key": {"id": 50}}`)
+			case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/v2/account/keys/"):
+				rw.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(rw, `{"id": "not_found", "message": "not found"}`)
+			case req.Method == "POST" && req.URL.Path == "/v2/account/keys":
+				rw.WriteHeader(http.StatusCreated)
+				fmt.Fprint(rw, `{"ssh_key": {"id": 60}}`)
+			default:
+				t.Errorf("%s: unexpected request %s %s", tc.desc, req.Method, req.URL)
+			}
+		})
+
+		opt := buildOptions(tc.opts)
+		var created []createdResource
+		keys, registeredIDs, err := dropletSSHKeys(context.Background(), client, &opt, &created)
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %s", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(keys, tc.want) {
+			t.Errorf("%s: got keys %+v, want %+v", tc.desc, keys, tc.want)
+		}
+		if !reflect.DeepEqual(registeredIDs, tc.wantRegistered) {
+			t.Errorf("%s: registered %v, want %v", tc.desc, registeredIDs, tc.wantRegistered)
+		}
+		if len(created) != len(tc.wantRegistered) {
+			t.Errorf("%s: got %d resources to roll back, want %d", tc.desc, len(created), len(tc.wantRegistered))
+		}
+		if requests != tc.wantRequests {
+			t.Errorf("%s: got %d requests, want %d", tc.desc, requests, tc.wantRequests)
+		}
+	}
+}
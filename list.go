@@ -0,0 +1,79 @@
+package dochaincore
+
+import (
+	"context"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// markerTag is applied to every droplet created by Deploy.
+const markerTag = "dochaincore"
+
+// dropletTags returns the tags to apply to a new droplet: the
+// marker tag followed by any user-provided tags.
+func dropletTags(tags []string) []string {
+	all := []string{markerTag}
+	for _, t := range tags {
+		if t != markerTag {
+			all = append(all, t)
+		}
+	}
+	return all
+}
+
+// List returns every Chain Core deployed to the DigitalOcean account,
+// reconstructed from the droplets carrying the "dochaincore" tag.
+// The returned Cores don't have SSH keys, so they can be passed to
+// Destroy but not CreateClientToken.
+func List(ctx context.Context, accessToken string) ([]*Core, error) {
+	client := newClient(ctx, accessToken)
+	droplets, err := listTaggedDroplets(ctx, client, markerTag)
+	if err != nil {
+		return nil, err
+	}
+
+	cores := make([]*Core, 0, len(droplets))
+	for i := range droplets {
+		cores = append(cores, coreFromDroplet(&droplets[i]))
+	}
+	return cores, nil
+}
+
+// listTaggedDroplets returns all droplets with the provided tag,
+// following pagination.
+func listTaggedDroplets(ctx context.Context, client *godo.Client, tag string) ([]godo.Droplet, error) {
+	var all []godo.Droplet
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		droplets, resp, err := client.Droplets.ListByTag(ctx, tag, opt)
+		all = append(all, droplets...)
+		return resp, err
+	})
+	return all, err
+}
+
+func coreFromDroplet(d *godo.Droplet) *Core {
+	c := &Core{
+		Name:      d.Name,
+		DropletID: d.ID,
+		Size:      d.SizeSlug,
+		Tags:      d.Tags,
+		Status:    d.Status,
+	}
+	if len(d.VolumeIDs) > 0 {
+		c.VolumeID = d.VolumeIDs[0]
+	}
+	if d.Region != nil {
+		c.Region = d.Region.Slug
+	}
+	if d.Image != nil {
+		c.Image = d.Image.Slug
+	}
+	if t, err := time.Parse(time.RFC3339, d.Created); err == nil {
+		c.CreatedAt = t
+	}
+	c.IPv4Address, _ = d.PublicIPv4()
+	c.IPv6Address, _ = d.PublicIPv6()
+	c.PrivateIPv4Address, _ = d.PrivateIPv4()
+	return c
+}
@@ -0,0 +1,24 @@
+package dochaincore
+
+import "github.com/digitalocean/godo"
+
+// paginate calls list with each page of a DigitalOcean list endpoint
+// in turn, until the last page. list is responsible for collecting
+// the page's items.
+func paginate(list func(opt *godo.ListOptions) (*godo.Response, error)) error {
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		resp, err := list(opt)
+		if err != nil {
+			return err
+		}
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return err
+		}
+		opt.Page = page + 1
+	}
+}
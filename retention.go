@@ -0,0 +1,64 @@
+package dochaincore
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy decides which of a Core's backups to prune.
+// A snapshot is pruned if it isn't one of the KeepLast most recent
+// snapshots or if it's older than MaxAge. A zero KeepLast or MaxAge
+// disables that rule. The most recent snapshot is never pruned.
+type RetentionPolicy struct {
+	KeepLast int
+	MaxAge   time.Duration
+}
+
+// Prune returns the snapshots that the policy would delete as of now.
+func (p RetentionPolicy) Prune(snaps []*Snapshot, now time.Time) []*Snapshot {
+	sorted := make([]*Snapshot, len(snaps))
+	copy(sorted, snaps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CreatedAt.After(sorted[j].CreatedAt) })
+
+	var pruned []*Snapshot
+	for i, s := range sorted {
+		if i == 0 {
+			continue // always keep the most recent snapshot
+		}
+		if (p.KeepLast > 0 && i >= p.KeepLast) || (p.MaxAge > 0 && now.Sub(s.CreatedAt) > p.MaxAge) {
+			pruned = append(pruned, s)
+		}
+	}
+	return pruned
+}
+
+// PruneBackups deletes the snapshots of the Core's volume selected by
+// the retention policy and returns them. Only snapshots taken by
+// Backup are considered; snapshots taken by hand are never deleted.
+// If dryRun is true, nothing is deleted.
+func PruneBackups(ctx context.Context, accessToken string, c *Core, policy RetentionPolicy, dryRun bool) ([]*Snapshot, error) {
+	all, err := ListBackups(ctx, accessToken, c)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []*Snapshot
+	for _, s := range all {
+		if isBackup(c, s) {
+			snaps = append(snaps, s)
+		}
+	}
+	pruned := policy.Prune(snaps, time.Now())
+	if dryRun {
+		return pruned, nil
+	}
+
+	client := newClient(ctx, accessToken)
+	for i, s := range pruned {
+		_, err := client.Storage.DeleteSnapshot(ctx, s.ID)
+		if err != nil && !isNotFound(err) {
+			return pruned[:i], err
+		}
+	}
+	return pruned, nil
+}
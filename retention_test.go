@@ -0,0 +1,59 @@
+package dochaincore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyPrune(t *testing.T) {
+	now := time.Date(2017, 6, 10, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	snaps := []*Snapshot{
+		{ID: "d3", CreatedAt: now.Add(-3 * day)},
+		{ID: "d0", CreatedAt: now},
+		{ID: "d9", CreatedAt: now.Add(-9 * day)},
+		{ID: "d1", CreatedAt: now.Add(-1 * day)},
+	}
+
+	testCases := []struct {
+		policy RetentionPolicy
+		want   []string
+	}{
+		{policy: RetentionPolicy{}, want: nil},
+		{policy: RetentionPolicy{KeepLast: 2}, want: []string{"d3", "d9"}},
+		{policy: RetentionPolicy{MaxAge: 2 * day}, want: []string{"d3", "d9"}},
+		{policy: RetentionPolicy{KeepLast: 3, MaxAge: 5 * day}, want: []string{"d9"}},
+		{policy: RetentionPolicy{KeepLast: 1}, want: []string{"d1", "d3", "d9"}},
+		// The most recent snapshot is kept regardless of its age.
+		{policy: RetentionPolicy{MaxAge: time.Nanosecond}, want: []string{"d1", "d3", "d9"}},
+	}
+	for _, tc := range testCases {
+		var got []string
+		for _, s := range tc.policy.Prune(snaps, now.Add(time.Second)) {
+			got = append(got, s.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v: pruned %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}
+
+func TestIsBackup(t *testing.T) {
+	c := &Core{Name: "chain"}
+	testCases := []struct {
+		name string
+		want bool
+	}{
+		{name: "chain-20170102-150405", want: true},
+		{name: "chain-before-upgrade", want: false},
+		{name: "chain-20170102-150405-manual", want: false},
+		{name: "other-20170102-150405", want: false},
+	}
+	for _, tc := range testCases {
+		got := isBackup(c, &Snapshot{Name: tc.name})
+		if got != tc.want {
+			t.Errorf("isBackup(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeployError is returned by Deploy when deployment fails after
+// some DigitalOcean resources were already created. It reports
+// which of those resources were rolled back and which still exist.
+type DeployError struct {
+	// Err is the error that caused the deployment to fail.
+	Err error
+
+	// RolledBack lists the resources that were deleted.
+	RolledBack []string
+
+	// Remaining lists the resources that still exist, either because
+	// rollback was disabled or because deleting them failed.
+	Remaining []string
+
+	// RollbackErrs holds any errors encountered while deleting
+	// resources.
+	RollbackErrs []error
+}
+
+func (e *DeployError) Error() string {
+	msg := e.Err.Error()
+	if len(e.RolledBack) > 0 {
+		msg += fmt.Sprintf(" (rolled back %s)", strings.Join(e.RolledBack, ", "))
+	}
+	if len(e.Remaining) > 0 {
+		msg += fmt.Sprintf(" (left behind %s)", strings.Join(e.Remaining, ", "))
+	}
+	return msg
+}
+
+// createdResource is a DigitalOcean resource created during
+// deployment, along with a func to delete it.
+type createdResource struct {
+	name    string
+	destroy func(context.Context) error
+}
+
+// rollback deletes the created resources in reverse order of their
+// creation and returns a *DeployError describing the result. If
+// enabled is false, the resources are only reported.
+func rollback(resources []createdResource, enabled bool, cause error) error {
+	if len(resources) == 0 {
+		return cause
+	}
+	deployErr := &DeployError{Err: cause}
+	if !enabled {
+		for _, r := range resources {
+			deployErr.Remaining = append(deployErr.Remaining, r.name)
+		}
+		return deployErr
+	}
+
+	// The deploy context may be the reason we're rolling back, so
+	// use a fresh one for cleanup.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	for i := len(resources) - 1; i >= 0; i-- {
+		err := resources[i].destroy(ctx)
+		if err != nil {
+			deployErr.Remaining = append(deployErr.Remaining, resources[i].name)
+			deployErr.RollbackErrs = append(deployErr.RollbackErrs, err)
+			continue
+		}
+		deployErr.RolledBack = append(deployErr.RolledBack, resources[i].name)
+	}
+	return deployErr
+}
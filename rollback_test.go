@@ -0,0 +1,54 @@
+package dochaincore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	var destroyed []string
+	resource := func(name string, err error) createdResource {
+		return createdResource{
+			name: name,
+			destroy: func(context.Context) error {
+				destroyed = append(destroyed, name)
+				return err
+			},
+		}
+	}
+	resources := []createdResource{
+		resource("volume a", nil),
+		resource("droplet 1", errors.New("boom")),
+		resource("ssh key 2", nil),
+	}
+
+	cause := errors.New("deploy failed")
+	err := rollback(resources, true, cause)
+	deployErr, ok := err.(*DeployError)
+	if !ok {
+		t.Fatalf("got %T, want *DeployError", err)
+	}
+	if deployErr.Err != cause {
+		t.Errorf("got cause %v, want %v", deployErr.Err, cause)
+	}
+	if want := []string{"ssh key 2", "droplet 1", "volume a"}; !reflect.DeepEqual(destroyed, want) {
+		t.Errorf("destroyed %v, want %v", destroyed, want)
+	}
+	if want := []string{"ssh key 2", "volume a"}; !reflect.DeepEqual(deployErr.RolledBack, want) {
+		t.Errorf("rolled back %v, want %v", deployErr.RolledBack, want)
+	}
+	if want := []string{"droplet 1"}; !reflect.DeepEqual(deployErr.Remaining, want) {
+		t.Errorf("remaining %v, want %v", deployErr.Remaining, want)
+	}
+
+	destroyed = nil
+	deployErr = rollback(resources, false, cause).(*DeployError)
+	if len(destroyed) != 0 {
+		t.Errorf("destroyed %v with rollback disabled", destroyed)
+	}
+	if len(deployErr.Remaining) != len(resources) {
+		t.Errorf("got %d remaining resources, want %d", len(deployErr.Remaining), len(resources))
+	}
+}
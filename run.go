@@ -0,0 +1,99 @@
+package dochaincore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExitError is returned by Run and RunStreaming when a remote command
+// exits with a non-zero status or is killed by a signal.
+type ExitError struct {
+	Cmd    string
+	Status int    // the exit status, if the command exited
+	Signal string // the signal that killed the command, such as "TERM"
+	Stderr string // the command's standard error, captured by Run
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("running %q: exit status %d", e.Cmd, e.Status)
+	if e.Signal != "" {
+		msg = fmt.Sprintf("running %q: killed by signal %s", e.Cmd, e.Signal)
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// Run runs cmd on the Core's droplet as root and returns its standard
+// output. If the command fails, Run returns an *ExitError that
+// includes its standard error. See RunStreaming.
+func (c *Core) Run(ctx context.Context, cmd string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := c.RunStreaming(ctx, cmd, &stdout, &stderr)
+	return stdout.String(), withStderr(err, &stderr)
+}
+
+// RunStreaming runs cmd on the Core's droplet as root, copying its
+// standard output and standard error to stdout and stderr as it runs.
+// Either writer may be nil to discard the output. It returns an
+// *ExitError if the command exits with a non-zero status.
+//
+// If ctx is done before the command exits, RunStreaming sends the
+// command SIGTERM, closes the session and returns ctx.Err().
+//
+// Commands run in their own session on the Core's SSH connection, so
+// RunStreaming may be called concurrently. c must come from Deploy or
+// LoadCore.
+func (c *Core) RunStreaming(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	return c.run(ctx, cmd, nil, stdout, stderr)
+}
+
+// run runs cmd like RunStreaming, also copying stdin to its standard
+// input if stdin isn't nil. If ctx is done, run returns without
+// waiting for a blocked read from stdin.
+func (c *Core) run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	session, err := c.NewSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	err = session.Start(cmd)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+		// Depending on the version of x/crypto/ssh, Wait may also
+		// wait for stdin to be copied, which can block reading
+		// from stdin indefinitely. Closing the session makes the
+		// copy fail as soon as the read returns.
+		if stdin == nil {
+			<-done
+		}
+		return ctx.Err()
+	}
+
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *ssh.ExitError:
+		return &ExitError{Cmd: cmd, Status: e.ExitStatus(), Signal: e.Signal()}
+	default:
+		return fmt.Errorf("running %q: %s", cmd, err)
+	}
+}
@@ -0,0 +1,132 @@
+package dochaincore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+	ctx := context.Background()
+
+	out, err := c.Run(ctx, "echo hello; echo ignored >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello\n" {
+		t.Errorf("got output %q, want %q", out, "hello\n")
+	}
+	client := c.client
+
+	_, err = c.Run(ctx, "echo failed >&2; exit 3")
+	exitErr, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("got error %#v, want *ExitError", err)
+	}
+	if exitErr.Status != 3 || exitErr.Stderr != "failed" {
+		t.Errorf("got status %d and stderr %q, want 3 and %q", exitErr.Status, exitErr.Stderr, "failed")
+	}
+	if c.client != client {
+		t.Error("Run didn't reuse the SSH client")
+	}
+}
+
+func TestRunStreaming(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	err := c.RunStreaming(context.Background(), "echo out; echo err >&2", &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("got stdout %q and stderr %q, want %q and %q", stdout.String(), stderr.String(), "out\n", "err\n")
+	}
+}
+
+func TestRunStreamingCancel(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "dochaincore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signalled := filepath.Join(dir, "signalled")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	cmd := "trap 'touch " + signalled + "; exit 1' TERM; while true; do sleep 0.1; done"
+	err = c.RunStreaming(ctx, cmd, nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(signalled); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("remote command wasn't signalled")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRunReconnect(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+	ctx := context.Background()
+
+	_, err := c.Run(ctx, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate the connection dying underneath the Core.
+	c.client.Close()
+
+	out, err := c.Run(ctx, "echo reconnected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "reconnected\n" {
+		t.Errorf("got output %q, want %q", out, "reconnected\n")
+	}
+
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Run(ctx, "true")
+	if err != nil {
+		t.Errorf("Run after Close: %s", err)
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+
+	const n = 5
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := c.Run(context.Background(), "sleep 0.1")
+			errs <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
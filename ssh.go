@@ -2,59 +2,324 @@ package dochaincore
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+// KeyType is a type of SSH key that Deploy can generate for the
+// deployer.
+type KeyType string
+
+const (
+	KeyTypeEd25519 KeyType = "ed25519"
+	KeyTypeRSA3072 KeyType = "rsa-3072"
+	KeyTypeRSA4096 KeyType = "rsa-4096"
+	KeyTypeECDSA   KeyType = "ecdsa-p256"
+)
+
+// SSHKeyType sets the type of the SSH key that Deploy generates to
+// connect to the droplet. The default is KeyTypeEd25519. It has no
+// effect when combined with DeployerKeyFrom.
+func SSHKeyType(t KeyType) Option {
+	return func(opt *options) { opt.sshKeyType = t }
+}
+
 type sshKeyPair struct {
-	privateKey    *rsa.PrivateKey
+	privateKey    crypto.Signer
 	authorizedKey []byte
 }
 
-func createSSHKeyPair() (*sshKeyPair, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+func createSSHKeyPair(keyType KeyType) (*sshKeyPair, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch keyType {
+	case KeyTypeEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case KeyTypeRSA3072:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 3072)
+	case KeyTypeRSA4096:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeECDSA:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type %q", keyType)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return newSSHKeyPair(privateKey)
+}
 
-	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+func newSSHKeyPair(privateKey crypto.Signer) (*sshKeyPair, error) {
+	publicKey, err := ssh.NewPublicKey(privateKey.Public())
 	if err != nil {
 		return nil, err
 	}
-	authorizedKey := ssh.MarshalAuthorizedKey(publicKey)
-
 	return &sshKeyPair{
 		privateKey:    privateKey,
-		authorizedKey: authorizedKey,
+		authorizedKey: ssh.MarshalAuthorizedKey(publicKey),
 	}, nil
 }
 
-func connect(ctx context.Context, host string, keypair *sshKeyPair) (*ssh.Session, error) {
-	signer, err := ssh.NewSignerFromKey(keypair.privateKey)
+// zero overwrites the private key's secret values. It's best effort:
+// copies made by the runtime or by the crypto packages' precomputation
+// can't be reached.
+func (k *sshKeyPair) zero() {
+	switch key := k.privateKey.(type) {
+	case *rsa.PrivateKey:
+		zeroInt(key.D)
+		for _, p := range key.Primes {
+			zeroInt(p)
+		}
+		zeroInt(key.Precomputed.Dp)
+		zeroInt(key.Precomputed.Dq)
+		zeroInt(key.Precomputed.Qinv)
+	case *ecdsa.PrivateKey:
+		zeroInt(key.D)
+	case ed25519.PrivateKey:
+		for i := range key {
+			key[i] = 0
+		}
+	}
+}
+
+func zeroInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+// hostKeyPair is an SSH host key generated locally for a droplet, so
+// that the droplet's identity is known before it boots.
+type hostKeyPair struct {
+	privateKeyPEM []byte
+	publicKey     ssh.PublicKey
+}
+
+func createHostKeyPair() (*hostKeyPair, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hostKeyPair{
+		privateKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		publicKey:     publicKey,
+	}, nil
+}
+
+// KnownHostsLine returns a line for an OpenSSH known_hosts file that
+// trusts the droplet's host key at each of the Core's addresses.
+func (c *Core) KnownHostsLine() (string, error) {
+	hostKey, err := c.hostKey()
+	if err != nil {
+		return "", err
+	}
+	var addrs []string
+	for _, addr := range []string{c.DNSName, c.FloatingIP, c.IPv4Address, c.IPv6Address} {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return knownhosts.Line(addrs, hostKey), nil
+}
+
+// hostKey parses the Core's pinned SSH host key.
+func (c *Core) hostKey() (ssh.PublicKey, error) {
+	if c.HostKey == "" {
+		return nil, errors.New("Core has no pinned SSH host key")
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing host key: %s", err)
+	}
+	return hostKey, nil
+}
+
+// sshPort is the port that droplets' SSH servers listen on.
+var sshPort = "22"
+
+// keepaliveInterval is how often an SSH connection to a droplet is
+// probed, and keepaliveTimeout is how long a probe may go unanswered
+// before the connection is considered dead.
+const (
+	keepaliveInterval = 30 * time.Second
+	keepaliveTimeout  = 15 * time.Second
+)
+
+// dial opens an SSH connection to the Core's droplet as root,
+// authenticating with the deployer key and requiring the droplet to
+// present its pinned host key.
+func dial(ctx context.Context, c *Core) (*ssh.Client, error) {
+	if c.Finalized {
+		return nil, errors.New("Core was finalized; its SSH key was removed")
+	}
+	if c.ssh == nil {
+		return nil, errors.New("Core has no SSH key; load it with LoadCore")
+	}
+	hostKey, err := c.hostKey()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(c.ssh.privateKey)
 	if err != nil {
 		return nil, err
 	}
 
 	config := &ssh.ClientConfig{
-		User:            "root",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:              "root",
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback:   ssh.FixedHostKey(hostKey),
+		HostKeyAlgorithms: []string{hostKey.Type()},
+	}
+
+	addr := net.JoinHostPort(c.IPv4Address, sshPort)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
 	}
-	// propogate the context's deadline as a timeout on the config
+	// propogate the context's deadline to the handshake
 	if deadline, ok := ctx.Deadline(); ok {
-		config.Timeout = deadline.Sub(time.Now())
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// sshClient returns the Core's SSH client, connecting on first use
+// or after the previous connection failed.
+func (c *Core) sshClient(ctx context.Context) (*ssh.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	client, err := dial(ctx, c)
+	if err != nil {
+		return nil, err
 	}
+	c.client = client
+	go c.keepalive(client)
+	return client, nil
+}
+
+// keepalive probes the connection every keepaliveInterval until it's
+// closed or stops responding, then forgets the client so that the
+// next use reconnects.
+func (c *Core) keepalive(client *ssh.Client) {
+	defer c.forgetClient(client)
+
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
 
-	client, err := ssh.Dial("tcp", host+":22", config)
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		select {
+		case err := <-replied:
+			if err != nil {
+				client.Close()
+				return
+			}
+		case <-time.After(keepaliveTimeout):
+			client.Close()
+			return
+		}
+	}
+}
+
+// forgetClient clears the Core's SSH client if it's still client.
+func (c *Core) forgetClient(client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == client {
+		c.client = nil
+	}
+}
+
+// NewSession opens a new SSH session on the Core's droplet as root,
+// reconnecting if the Core's connection has failed. Sessions share one
+// connection and may be used concurrently, for example to tail logs
+// while creating a client token. The caller must close the session.
+//
+// c must come from Deploy or LoadCore.
+func (c *Core) NewSession(ctx context.Context) (*ssh.Session, error) {
+	client, err := c.sshClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 	session, err := client.NewSession()
+	if _, ok := err.(*ssh.OpenChannelError); err == nil || ok {
+		// The server refused the session, for example because
+		// too many are open, but the connection is fine.
+		return session, err
+	}
+
+	// The connection died since it was last used.
+	client.Close()
+	c.forgetClient(client)
+	client, err = c.sshClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return session, nil
+	return client.NewSession()
+}
+
+// Close closes the Core's SSH connection, if it has one, interrupting
+// any commands still running. The Core reconnects if it's used again.
+func (c *Core) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
 }
@@ -0,0 +1,313 @@
+package dochaincore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newTestSSHServer starts an SSH server on localhost that runs exec
+// requests with /bin/sh, and returns a Core that connects to it. The
+// returned function stops the server.
+func newTestSSHServer(t *testing.T) (*Core, func()) {
+	return startTestSSHServer(t, new(testSSHServer))
+}
+
+// testSSHServer changes the behavior of a server started with
+// startTestSSHServer.
+type testSSHServer struct {
+	mu sync.Mutex
+	// authorizedKeys is the path of a file listing the keys allowed
+	// to log in. If it's empty, only the Core's key may log in.
+	authorizedKeys string
+	// dropConns makes the server close new connections before the
+	// SSH handshake.
+	dropConns bool
+}
+
+func (s *testSSHServer) setDropConns(drop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropConns = drop
+}
+
+// authorized returns true if key may log in, given that the Core's
+// key is deployer.
+func (s *testSSHServer) authorized(key, deployer ssh.PublicKey) bool {
+	s.mu.Lock()
+	path := s.authorizedKeys
+	s.mu.Unlock()
+	if path == "" {
+		return bytes.Equal(key.Marshal(), deployer.Marshal())
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	for len(b) > 0 {
+		var k ssh.PublicKey
+		k, _, _, b, err = ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return false
+		}
+		if bytes.Equal(key.Marshal(), k.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// startTestSSHServer is like newTestSSHServer, but the server's
+// behavior can be changed through srv.
+func startTestSSHServer(t *testing.T, srv *testSSHServer) (*Core, func()) {
+	keypair, err := createSSHKeyPair(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeys, err := createHostKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.ParsePrivateKey(hostKeys.privateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, _, _, _, err := ssh.ParseAuthorizedKey(keypair.authorizedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if srv.authorized(key, authorized) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			drop := srv.dropConns
+			srv.mu.Unlock()
+			if drop {
+				conn.Close()
+				continue
+			}
+			go serveTestSSH(conn, config)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	oldPort := sshPort
+	sshPort = port
+	c := &Core{
+		IPv4Address: host,
+		HostKey:     string(ssh.MarshalAuthorizedKey(hostKeys.publicKey)),
+		ssh:         keypair,
+	}
+	return c, func() {
+		c.Close()
+		ln.Close()
+		sshPort = oldPort
+	}
+}
+
+func serveTestSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go serveTestSession(ch, requests)
+	}
+}
+
+func serveTestSession(ch ssh.Channel, requests <-chan *ssh.Request) {
+	var cmd *exec.Cmd
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			cmd = exec.Command("/bin/sh", "-c", payload.Command)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+			err := cmd.Start()
+			req.Reply(err == nil, nil)
+			if err != nil {
+				ch.Close()
+				return
+			}
+			go func(cmd *exec.Cmd) {
+				cmd.Wait()
+				status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+				if status.Signaled() {
+					ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+						Signal     string
+						CoreDumped bool
+						Error      string
+						Lang       string
+					}{Signal: "TERM"}))
+				} else {
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status.ExitStatus())}))
+				}
+				ch.Close()
+			}(cmd)
+		case "signal":
+			if cmd != nil && cmd.Process != nil {
+				cmd.Process.Signal(syscall.SIGTERM)
+			}
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func TestSSHKeyTypes(t *testing.T) {
+	hostKeys, err := createHostKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeRSA3072, KeyTypeRSA4096, KeyTypeECDSA} {
+		keypair, err := createSSHKeyPair(keyType)
+		if err != nil {
+			t.Errorf("%s: %s", keyType, err)
+			continue
+		}
+
+		s, err := buildUserData(newUserDataParams(keypair, hostKeys, "chain-core-storage"))
+		if err != nil {
+			t.Errorf("%s: %s", keyType, err)
+			continue
+		}
+		authorizedKey := strings.TrimSpace(string(keypair.authorizedKey))
+		if !strings.Contains(s, "  - "+authorizedKey+"\n") {
+			t.Errorf("%s: user data missing authorized key %q:\n%s", keyType, authorizedKey, s)
+		}
+
+		signer, err := ssh.NewSignerFromKey(keypair.privateKey)
+		if err != nil {
+			t.Errorf("%s: %s", keyType, err)
+			continue
+		}
+		if got := ssh.MarshalAuthorizedKey(signer.PublicKey()); !bytes.Equal(got, keypair.authorizedKey) {
+			t.Errorf("%s: signer public key %s, want %s", keyType, got, keypair.authorizedKey)
+		}
+		data := []byte("dochaincore")
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Errorf("%s: %s", keyType, err)
+			continue
+		}
+		err = signer.PublicKey().Verify(data, sig)
+		if err != nil {
+			t.Errorf("%s: %s", keyType, err)
+		}
+	}
+}
+
+func TestCreateSSHKeyPairUnsupported(t *testing.T) {
+	_, err := createSSHKeyPair("rsa-1024")
+	if err == nil {
+		t.Error("createSSHKeyPair succeeded for rsa-1024")
+	}
+}
+
+func TestRunHostKeyMismatch(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+
+	// Pin a host key other than the one the server presents, as if
+	// the connection were intercepted.
+	other, err := createHostKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.HostKey = string(ssh.MarshalAuthorizedKey(other.publicKey))
+
+	_, err = c.Run(context.Background(), "echo hello")
+	if err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("got error %v, want a host key mismatch", err)
+	}
+}
+
+func TestKnownHostsLine(t *testing.T) {
+	hostKeys, err := createHostKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Core{
+		IPv4Address: "203.0.113.5",
+		DNSName:     "ledger.example.com",
+		HostKey:     string(ssh.MarshalAuthorizedKey(hostKeys.publicKey)),
+	}
+	line, err := c.KnownHostsLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ledger.example.com,203.0.113.5 " + strings.TrimSpace(c.HostKey)
+	if line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+
+	// OpenSSH's known_hosts parsing must trust the key at each
+	// address and no other key.
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(line + "\n")
+	f.Close()
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := createHostKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 22}
+	for _, host := range []string{"ledger.example.com:22", "203.0.113.5:22"} {
+		if err := callback(host, remote, hostKeys.publicKey); err != nil {
+			t.Errorf("%s: %s", host, err)
+		}
+		if err := callback(host, remote, other.publicKey); err == nil {
+			t.Errorf("%s: trusted a different host key", host)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package dochaincore
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const stateVersion = 1
+
+// scrypt parameters used to derive the key that encrypts the
+// Core's SSH private key.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// coreState is the serialized form of a Core written by SaveCore.
+type coreState struct {
+	Version int `json:"version"`
+	*Core
+	PrivateKey *encryptedKey `json:"private_key,omitempty"`
+}
+
+// encryptedKey is a PKCS #8 private key encrypted with AES-256-GCM
+// using a key derived from a passphrase with scrypt.
+type encryptedKey struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SaveCore writes c to the file at path so that it can be restored
+// later with LoadCore. The Core's SSH private key is encrypted with
+// the provided passphrase. The file is created with 0600 permissions
+// because it also contains the Core's client tokens.
+func SaveCore(path string, c *Core, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("a passphrase is required to save a Core")
+	}
+
+	state := coreState{Version: stateVersion, Core: c}
+	if c.ssh != nil {
+		key, err := encryptPrivateKey(c.ssh, passphrase)
+		if err != nil {
+			return err
+		}
+		state.PrivateKey = key
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that a crash can't
+	// leave a truncated state file behind.
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, append(b, '\n'), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCore reads a Core previously written by SaveCore, decrypting
+// its SSH private key with the provided passphrase.
+func LoadCore(path string, passphrase []byte) (*Core, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := coreState{Core: new(Core)}
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %s", path, err)
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+
+	if state.PrivateKey != nil {
+		state.Core.ssh, err = decryptPrivateKey(state.PrivateKey, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state.Core, nil
+}
+
+func encryptPrivateKey(keypair *sshKeyPair, passphrase []byte) (*encryptedKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(keypair.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &encryptedKey{Salt: make([]byte, 16)}
+	_, err = rand.Read(key.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStateAEAD(passphrase, key.Salt)
+	if err != nil {
+		return nil, err
+	}
+	key.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(key.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	key.Ciphertext = aead.Seal(nil, key.Nonce, der, nil)
+	return key, nil
+}
+
+func decryptPrivateKey(key *encryptedKey, passphrase []byte) (*sshKeyPair, error) {
+	aead, err := newStateAEAD(passphrase, key.Salt)
+	if err != nil {
+		return nil, err
+	}
+	der, err := aead.Open(nil, key.Nonce, key.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt SSH private key: wrong passphrase?")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH private key type %T", parsed)
+	}
+	return newSSHKeyPair(privateKey)
+}
+
+func newStateAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	k, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
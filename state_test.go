@@ -0,0 +1,65 @@
+package dochaincore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadCore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dochaincore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keypair, err := createSSHKeyPair(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Core{
+		DropletID:    1234,
+		VolumeID:     "abcd",
+		IPv4Address:  "10.0.0.1",
+		Region:       "sfo2",
+		Size:         "s-1vcpu-1gb",
+		Image:        "ubuntu-17-04-x64",
+		CreatedAt:    time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC),
+		ClientTokens: []string{"do:abc"},
+		ssh:          keypair,
+	}
+
+	path := filepath.Join(dir, "core.json")
+	err = SaveCore(path, want, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("PRIVATE KEY")) {
+		t.Error("state file contains an unencrypted private key")
+	}
+
+	_, err = LoadCore(path, []byte("wrong"))
+	if err == nil {
+		t.Error("LoadCore succeeded with the wrong passphrase")
+	}
+
+	got, err := LoadCore(path, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.ssh.authorizedKey, want.ssh.authorizedKey) {
+		t.Errorf("got authorized key %s, want %s", got.ssh.authorizedKey, want.ssh.authorizedKey)
+	}
+	got.ssh, want.ssh = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
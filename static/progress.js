@@ -31,12 +31,18 @@ $(document).ready(function() {
           } else if (resp.status == 'creating client token') {
               $('#status-line').text('Creating client token…');
               updateProgressBar(98, 100, 2000);
+          } else if (resp.status == 'finalizing') {
+              $('#status-line').text('Securing droplet…');
+              updateProgressBar(99, 100, 2000);
           } else if (resp.status == 'done') {
               $('#status-line').text('Install complete');
               updateProgressBar(100);
               $('#client-token').text(resp.client_token);
-              $('#core-url').text('http://'+resp.ip_address+':1999');
-              $('#open-dashboard').attr('href', 'http://' + resp.client_token + '@' + resp.ip_address + ':1999/dashboard');
+              $('#core-url').text(resp.url);
+              if (resp.warning) {
+                  $('#core-warning').text(resp.warning);
+              }
+              $('#open-dashboard').attr('href', resp.url + '/dashboard');
               $('#core-info').css('display', 'block');
           } else {
               $('status-line').text('Install failed: ' + resp.status);
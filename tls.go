@@ -0,0 +1,166 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+// loadBalancerActive is the status of a DigitalOcean load balancer
+// that's ready to receive traffic.
+const loadBalancerActive = "active"
+
+// HTTPS serves Chain Core over HTTPS at the Core's DNS name, which
+// must be set with DNSName. A Caddy reverse proxy on the droplet
+// obtains a certificate from Let's Encrypt and forwards requests to
+// Chain Core, which no longer listens on public port 1999.
+//
+// Let's Encrypt validates the domain over port 80, so the certificate
+// is issued only once the DNS name resolves to the droplet.
+func HTTPS() Option {
+	return func(opt *options) { opt.https = true }
+}
+
+// LoadBalancer puts the Core behind a new DigitalOcean load balancer
+// that terminates TLS on port 443 with the account's certificate
+// certificateID and forwards requests to Chain Core on port 1999.
+// The certificate must cover the Core's DNS name, which must be set
+// with DNSName and points at the load balancer. Destroy deletes the
+// load balancer.
+//
+// The load balancer reaches Chain Core over the droplet's public
+// port 1999, so that port stays open to plain HTTP from anywhere.
+// Use HTTPS instead to close it.
+func LoadBalancer(certificateID string) Option {
+	return func(opt *options) { opt.lbCertificateID = certificateID }
+}
+
+// validateTLS checks the HTTPS and LoadBalancer options, recording
+// any problems in verr.
+func validateTLS(ctx context.Context, client *godo.Client, opt *options, verr *ValidationError) error {
+	if opt.https && opt.lbCertificateID != "" {
+		verr.addf("HTTPS and LoadBalancer can't be used together")
+	}
+	if opt.https && opt.dnsDomain == "" {
+		verr.addf("HTTPS requires a DNS name")
+	}
+	if opt.lbCertificateID != "" && opt.dnsDomain == "" {
+		verr.addf("LoadBalancer requires a DNS name")
+	}
+	if opt.lbCertificateID == "" {
+		return nil
+	}
+
+	_, _, err := client.Certificates.Get(ctx, opt.lbCertificateID)
+	if isNotFound(err) {
+		verr.addf("certificate %s does not exist", opt.lbCertificateID)
+		return nil
+	}
+	return err
+}
+
+// setupLoadBalancer creates the Core's load balancer if one was
+// requested and waits until it's active, appending it to created.
+// With Idempotent, an existing load balancer for the droplet is
+// reused.
+func setupLoadBalancer(ctx context.Context, client *godo.Client, core *Core, opt *options, created *[]createdResource) error {
+	if opt.lbCertificateID == "" {
+		return nil
+	}
+
+	var lb *godo.LoadBalancer
+	var err error
+	if opt.idempotent {
+		lb, err = findLoadBalancer(ctx, client, core.Name, core.DropletID)
+		if err != nil {
+			return err
+		}
+	}
+	if lb != nil {
+		core.Adopted = append(core.Adopted, fmt.Sprintf("load balancer %s", lb.ID))
+	} else {
+		lb, _, err = client.LoadBalancers.Create(ctx, &godo.LoadBalancerRequest{
+			Name:   core.Name,
+			Region: opt.dropletRegion,
+			ForwardingRules: []godo.ForwardingRule{{
+				EntryProtocol:  "https",
+				EntryPort:      443,
+				TargetProtocol: "http",
+				TargetPort:     1999,
+				CertificateID:  opt.lbCertificateID,
+			}},
+			HealthCheck: &godo.HealthCheck{
+				Protocol:               "tcp",
+				Port:                   1999,
+				CheckIntervalSeconds:   10,
+				ResponseTimeoutSeconds: 5,
+				HealthyThreshold:       3,
+				UnhealthyThreshold:     3,
+			},
+			DropletIDs:          []int{core.DropletID},
+			RedirectHttpToHttps: true,
+		})
+		if err != nil {
+			return err
+		}
+		lbID := lb.ID
+		*created = append(*created, createdResource{
+			name:    fmt.Sprintf("load balancer %s", lbID),
+			destroy: func(ctx context.Context) error { return deleteLoadBalancer(ctx, client, lbID) },
+		})
+		core.Created = append(core.Created, fmt.Sprintf("load balancer %s", lbID))
+	}
+	lbID := lb.ID
+	core.LoadBalancerID = lbID
+	core.TLS = true
+
+	ctx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+	err = poll(ctx, func() (bool, error) {
+		lb, _, err = client.LoadBalancers.Get(ctx, lbID)
+		if err != nil {
+			return false, err
+		}
+		return lb.Status == loadBalancerActive && lb.IP != "", nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for load balancer %s: %s", lbID, err)
+	}
+	core.LoadBalancerIP = lb.IP
+	return nil
+}
+
+// findLoadBalancer returns the load balancer with the provided name
+// that balances the droplet, or nil if there isn't one.
+func findLoadBalancer(ctx context.Context, client *godo.Client, name string, dropletID int) (*godo.LoadBalancer, error) {
+	var found *godo.LoadBalancer
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		lbs, resp, err := client.LoadBalancers.List(ctx, opt)
+		for i := range lbs {
+			for _, id := range lbs[i].DropletIDs {
+				if lbs[i].Name == name && id == dropletID {
+					found = &lbs[i]
+				}
+			}
+		}
+		return resp, err
+	})
+	return found, err
+}
+
+// deleteLoadBalancer deletes the load balancer and waits until it's
+// gone.
+func deleteLoadBalancer(ctx context.Context, client *godo.Client, lbID string) error {
+	_, err := client.LoadBalancers.Delete(ctx, lbID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return poll(ctx, func() (bool, error) {
+		_, _, err := client.LoadBalancers.Get(ctx, lbID)
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
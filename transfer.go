@@ -0,0 +1,109 @@
+package dochaincore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TransferOption configures an Upload or Download.
+type TransferOption func(*transferOptions)
+
+type transferOptions struct {
+	progress func(transferred int64)
+}
+
+// Progress makes Upload or Download call f with the total number of
+// bytes transferred so far each time more data is transferred.
+func Progress(f func(transferred int64)) TransferOption {
+	return func(opt *transferOptions) { opt.progress = f }
+}
+
+// Upload copies everything read from r to remotePath on the Core's
+// droplet, creating or replacing the file with the provided
+// permissions. The file is written to a temporary file next to
+// remotePath and renamed into place, so a failed upload never leaves
+// a partial file at remotePath.
+//
+// Files are transferred over the Core's SSH connection, like Run.
+func (c *Core) Upload(ctx context.Context, r io.Reader, remotePath string, mode os.FileMode, opts ...TransferOption) error {
+	var opt transferOptions
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.progress != nil {
+		r = &progressReader{r: r, f: opt.progress}
+	}
+
+	cmd := fmt.Sprintf(`f=%s; t=$(mktemp "$f.XXXXXX") || exit 1; `+
+		`if cat > "$t" && chmod %o "$t" && mv -f "$t" "$f"; then exit 0; fi; rm -f "$t"; exit 1`,
+		shellQuote(remotePath), mode.Perm())
+	var stderr bytes.Buffer
+	err := c.run(ctx, cmd, r, nil, &stderr)
+	return withStderr(err, &stderr)
+}
+
+// Download copies the contents of remotePath on the Core's droplet
+// to w.
+//
+// Files are transferred over the Core's SSH connection, like Run.
+func (c *Core) Download(ctx context.Context, remotePath string, w io.Writer, opts ...TransferOption) error {
+	var opt transferOptions
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.progress != nil {
+		w = &progressWriter{w: w, f: opt.progress}
+	}
+
+	var stderr bytes.Buffer
+	err := c.run(ctx, "cat -- "+shellQuote(remotePath), nil, w, &stderr)
+	return withStderr(err, &stderr)
+}
+
+// withStderr records the captured standard error on err if it's an
+// *ExitError.
+func withStderr(err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*ExitError); ok {
+		exitErr.Stderr = string(bytes.TrimSpace(stderr.Bytes()))
+	}
+	return err
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+type progressReader struct {
+	r     io.Reader
+	f     func(int64)
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.total += int64(n)
+		p.f(p.total)
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	w     io.Writer
+	f     func(int64)
+	total int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 {
+		p.total += int64(n)
+		p.f(p.total)
+	}
+	return n, err
+}
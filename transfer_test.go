@@ -0,0 +1,96 @@
+package dochaincore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadDownload(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "dochaincore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	remotePath := filepath.Join(dir, "it's a file.conf")
+
+	want := bytes.Repeat([]byte("max_connections = 100\n"), 1000)
+	var uploaded int64
+	err = c.Upload(ctx, bytes.NewReader(want), remotePath, 0600, Progress(func(n int64) { uploaded = n }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != int64(len(want)) {
+		t.Errorf("upload progress reported %d bytes, want %d", uploaded, len(want))
+	}
+	info, err := os.Stat(remotePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %s, want %s", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	var got bytes.Buffer
+	var downloaded int64
+	err = c.Download(ctx, remotePath, &got, Progress(func(n int64) { downloaded = n }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("downloaded %d bytes that differ from the %d uploaded", got.Len(), len(want))
+	}
+	if downloaded != int64(len(want)) {
+		t.Errorf("download progress reported %d bytes, want %d", downloaded, len(want))
+	}
+
+	err = c.Download(ctx, filepath.Join(dir, "missing"), ioutil.Discard)
+	exitErr, ok := err.(*ExitError)
+	if !ok || !strings.Contains(exitErr.Stderr, "No such file") {
+		t.Errorf("got error %v, want *ExitError for missing file", err)
+	}
+}
+
+func TestUploadCancel(t *testing.T) {
+	c, stop := newTestSSHServer(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "dochaincore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	remotePath := filepath.Join(dir, "upload")
+
+	// The pipe is never written to or closed, so reading from it
+	// blocks until the test ends.
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.Upload(ctx, r, remotePath, 0600) }()
+
+	select {
+	case err = <-errc:
+		if err != context.DeadlineExceeded {
+			t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upload didn't return after its context was done")
+	}
+	if _, err := os.Stat(remotePath); !os.IsNotExist(err) {
+		t.Errorf("cancelled upload left a file at %s", remotePath)
+	}
+}
@@ -2,13 +2,22 @@ package dochaincore
 
 import (
 	"bytes"
+	"strings"
 	"text/template"
+
+	"golang.org/x/crypto/ssh"
 )
 
 const baseUserData = `
 #cloud-config
 ssh_authorized_keys:
   - {{.SSHAuthorizedKey}}
+ssh_deletekeys: true
+ssh_genkeytypes: []
+ssh_keys:
+  ecdsa_private: |
+{{indent 4 .HostPrivateKey}}
+  ecdsa_public: {{.HostPublicKey}}
 users:
   - name: chaincore
     sudo: ['ALL=(ALL) NOPASSWD:ALL']
@@ -16,27 +25,105 @@ users:
     shell: /bin/bash
 packages:
   - docker.io
+{{- with .Firewall}}
+{{- if and .HTTPFrom (not $.TLSDomain)}}
+write_files:
+  - path: /etc/ufw/after.rules
+    append: true
+    content: |
+      *filter
+      :DOCKER-USER - [0:0]
+{{- range .HTTPFromIPv4}}
+      -A DOCKER-USER -p tcp -m conntrack --ctorigdstport 1999 --ctdir ORIGINAL -s {{.}} -j RETURN
+{{- end}}
+      -A DOCKER-USER -p tcp -m conntrack --ctorigdstport 1999 --ctdir ORIGINAL -j DROP
+      COMMIT
+{{- end}}
+{{- end}}
 runcmd:
-  - mkfs.ext4 -F /dev/disk/by-id/scsi-0DO_Volume_chain-core-storage
-  - mkdir -p /mnt/chain-core-storage
-  - mount -o discard,defaults /dev/disk/by-id/scsi-0DO_Volume_chain-core-storage /mnt/chain-core-storage
-  - echo '/dev/disk/by-id/scsi-0DO_Volume_chain-core-storage /mnt/chain-core-storage ext4 defaults,nofail,discard 0 0' >> /etc/fstab
-  - docker run -p 1999:1999 --name dochaincore -v /mnt/chain-core-storage/postgresql/data:/var/lib/postgresql/data -v /mnt/chain-core-storage/logs:/var/log/chain -v /mnt/chain-core-storage/data:/root/.chaincore chaincore/developer
+{{- with .Firewall}}
+  - ufw default deny incoming
+  - ufw default allow outgoing
+{{- range .SSHFrom}}
+  - ufw allow proto tcp from {{.}} to any port 22
+{{- else}}
+  - ufw allow 22/tcp
+{{- end}}
+{{- if $.TLSDomain}}
+  - ufw allow 80/tcp
+{{- end}}
+{{- range .HTTPFrom}}
+  - ufw allow proto tcp from {{.}} to any port {{if $.TLSDomain}}443{{else}}1999{{end}}
+{{- else}}
+  - ufw allow {{if $.TLSDomain}}443{{else}}1999{{end}}/tcp
+{{- end}}
+  - ufw --force enable
+{{- end}}
+  - blkid {{.VolumeDevice}} || mkfs.ext4 {{.VolumeDevice}}
+  - mkdir -p {{.MountPoint}}
+  - mount -o discard,defaults {{.VolumeDevice}} {{.MountPoint}}
+  - echo '{{.VolumeDevice}} {{.MountPoint}} ext4 defaults,nofail,discard 0 0' >> /etc/fstab
+{{- if .TLSDomain}}
+  - docker run -d --restart unless-stopped --name caddy --network host -v {{.MountPoint}}/caddy:/data caddy:2 caddy reverse-proxy --from {{.TLSDomain}} --to 127.0.0.1:1999
+{{- end}}
+  - docker run -p {{if .TLSDomain}}127.0.0.1:{{end}}1999:1999 --name dochaincore -v {{.MountPoint}}/postgresql/data:/var/lib/postgresql/data -v {{.MountPoint}}/logs:/var/log/chain -v {{.MountPoint}}/data:/root/.chaincore chaincore/developer
 `
 
 type userDataParams struct {
 	SSHAuthorizedKey string
+	HostPrivateKey   string
+	HostPublicKey    string
+	VolumeDevice     string
+	MountPoint       string
+
+	// TLSDomain, if set, makes Caddy terminate TLS for the domain
+	// with a Let's Encrypt certificate and proxy to Chain Core,
+	// which then only listens on localhost.
+	TLSDomain string
+
+	// Firewall, if set, restricts access to SSH and Chain Core.
+	// Docker publishes ports around ufw, so access to Chain Core
+	// over IPv4 is also restricted in the DOCKER-USER chain.
+	Firewall *firewall
+}
+
+// newUserDataParams returns the parameters for a droplet that
+// authorizes the deployer key, presents the provided host key and
+// stores data on the named volume.
+func newUserDataParams(keypair *sshKeyPair, hostKeys *hostKeyPair, volumeName string) userDataParams {
+	return userDataParams{
+		SSHAuthorizedKey: strings.TrimSpace(string(keypair.authorizedKey)),
+		HostPrivateKey:   string(hostKeys.privateKeyPEM),
+		HostPublicKey:    strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKeys.publicKey))),
+		VolumeDevice:     volumeDevice(volumeName),
+		MountPoint:       "/mnt/" + volumeName,
+	}
+}
+
+// volumeDevice returns the path of the block device that DigitalOcean
+// exposes for the named volume once it's attached to a droplet.
+func volumeDevice(volumeName string) string {
+	return "/dev/disk/by-id/scsi-0DO_Volume_" + volumeName
 }
 
-func buildUserData(keypair *sshKeyPair) (string, error) {
-	t, err := template.New("userdata").Parse(baseUserData)
+// buildUserData renders the cloud-config that installs Chain Core.
+func buildUserData(params userDataParams) (string, error) {
+	t, err := template.New("userdata").Funcs(template.FuncMap{
+		"indent": indent,
+	}).Parse(baseUserData)
 	if err != nil {
 		return "", err
 	}
 
 	var buf bytes.Buffer
-	err = t.Execute(&buf, userDataParams{
-		SSHAuthorizedKey: string(keypair.authorizedKey),
-	})
+	err = t.Execute(&buf, params)
 	return string(buf.Bytes()), err
 }
+
+// indent indents each line of s by n spaces, for embedding
+// multi-line values in YAML block scalars.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return pad + strings.Join(lines, "\n"+pad)
+}
@@ -0,0 +1,161 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// Limits on the size of a DigitalOcean block storage volume.
+const (
+	minVolumeSizeGB = 1
+	maxVolumeSizeGB = 16 * 1024
+)
+
+// ValidationError is returned by Validate and Deploy when the
+// deployment options are invalid. It lists every problem found.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return "invalid deployment options: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Errorf(format, args...))
+}
+
+// Validate checks the provided options against the DigitalOcean API
+// without creating anything. It verifies that the region exists and
+// supports block storage, that the droplet size and image are offered
+// in the region, that the volume size is within DigitalOcean's limits
+// and that any existing volume or snapshot is usable. Deploy calls
+// Validate automatically.
+//
+// If the options are invalid, Validate returns a *ValidationError.
+func Validate(ctx context.Context, accessToken string, opts ...Option) error {
+	opt := buildOptions(opts)
+	return validate(ctx, newClient(ctx, accessToken), &opt)
+}
+
+func validate(ctx context.Context, client *godo.Client, opt *options) error {
+	verr := new(ValidationError)
+
+	if opt.volumeSize < minVolumeSizeGB || opt.volumeSize > maxVolumeSizeGB {
+		verr.addf("volume size %dGB is outside the allowed range of %d-%dGB",
+			opt.volumeSize, minVolumeSizeGB, maxVolumeSizeGB)
+	}
+	if opt.missingDeployerKey {
+		verr.addf("DeployerKeyFrom was given a Core without an SSH key")
+	}
+	switch opt.sshKeyType {
+	case KeyTypeEd25519, KeyTypeRSA3072, KeyTypeRSA4096, KeyTypeECDSA:
+	default:
+		verr.addf("unsupported SSH key type %q", opt.sshKeyType)
+	}
+
+	regions, err := listRegions(ctx, client)
+	if err != nil {
+		return err
+	}
+	var region *godo.Region
+	for i := range regions {
+		if regions[i].Slug == opt.dropletRegion {
+			region = &regions[i]
+		}
+	}
+	switch {
+	case region == nil:
+		verr.addf("region %q does not exist", opt.dropletRegion)
+	case !region.Available:
+		verr.addf("region %s is not currently available", region.Slug)
+	case !contains(region.Features, "storage"):
+		verr.addf("region %s does not support block storage", region.Slug)
+	}
+
+	sizes, err := listSizes(ctx, client)
+	if err != nil {
+		return err
+	}
+	var size *godo.Size
+	for i := range sizes {
+		if sizes[i].Slug == opt.dropletSize {
+			size = &sizes[i]
+		}
+	}
+	switch {
+	case size == nil:
+		verr.addf("droplet size %q does not exist", opt.dropletSize)
+	case !size.Available:
+		verr.addf("droplet size %s is not currently available", size.Slug)
+	case region != nil && !contains(region.Sizes, size.Slug):
+		verr.addf("droplet size %s is not offered in region %s", size.Slug, region.Slug)
+	}
+
+	if region != nil {
+		err = validateImage(ctx, client, opt.dropletImage, region.Slug, verr)
+		if err != nil {
+			return err
+		}
+	}
+	err = validateVolume(ctx, client, opt, verr)
+	if err != nil {
+		return err
+	}
+	err = validateFloatingIP(ctx, client, opt, verr)
+	if err != nil {
+		return err
+	}
+	err = validateDNS(ctx, client, opt, verr)
+	if err != nil {
+		return err
+	}
+	err = validateTLS(ctx, client, opt, verr)
+	if err != nil {
+		return err
+	}
+	validateFirewall(opt, verr)
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// listRegions returns all DigitalOcean regions, following pagination.
+func listRegions(ctx context.Context, client *godo.Client) ([]godo.Region, error) {
+	var all []godo.Region
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		regions, resp, err := client.Regions.List(ctx, opt)
+		all = append(all, regions...)
+		return resp, err
+	})
+	return all, err
+}
+
+// listSizes returns all droplet sizes, following pagination.
+func listSizes(ctx context.Context, client *godo.Client) ([]godo.Size, error) {
+	var all []godo.Size
+	err := paginate(func(opt *godo.ListOptions) (*godo.Response, error) {
+		sizes, resp, err := client.Sizes.List(ctx, opt)
+		all = append(all, sizes...)
+		return resp, err
+	})
+	return all, err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
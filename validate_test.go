@@ -0,0 +1,163 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// testAPI responds to the DigitalOcean API requests made by validate.
+var testAPI = map[string]string{
+	"/v2/regions": `{"regions": [
+		{"slug": "sfo2", "available": true, "features": ["storage"], "sizes": ["s-1vcpu-1gb"]},
+		{"slug": "nyc1", "available": true, "features": [], "sizes": ["s-1vcpu-1gb"]},
+		{"slug": "ams1", "available": false, "features": ["storage"], "sizes": []}
+	]}`,
+	"/v2/sizes": `{"sizes": [
+		{"slug": "s-1vcpu-1gb", "available": true},
+		{"slug": "s-2vcpu-2gb", "available": true},
+		{"slug": "s-retired", "available": false}
+	]}`,
+	"/v2/images/ubuntu-24-04-x64": `{"image": {"slug": "ubuntu-24-04-x64", "regions": ["sfo2", "nyc1"]}}`,
+	"/v2/images/nyc-only":         `{"image": {"slug": "nyc-only", "regions": ["nyc1"]}}`,
+	"/v2/volumes/vol-sfo2":        `{"volume": {"id": "vol-sfo2", "name": "data", "region": {"slug": "sfo2"}}}`,
+	"/v2/volumes/vol-nyc1":        `{"volume": {"id": "vol-nyc1", "name": "old", "region": {"slug": "nyc1"}}}`,
+	"/v2/snapshots/snap":          `{"snapshot": {"id": "snap", "name": "backup", "regions": ["sfo2"], "min_disk_size": 200}}`,
+	"/v2/certificates/cert":       `{"certificate": {"id": "cert", "name": "ledger"}}`,
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		desc string
+		opts []Option
+		want []string
+	}{{
+		desc: "valid",
+		opts: []Option{VolumeSizeGB(200), VolumeFromSnapshot("snap")},
+	}, {
+		desc: "unknown region",
+		opts: []Option{DropletRegion("xyz1")},
+		want: []string{`region "xyz1" does not exist`},
+	}, {
+		desc: "unavailable region",
+		opts: []Option{DropletRegion("ams1")},
+		want: []string{
+			"region ams1 is not currently available",
+			"droplet size s-1vcpu-1gb is not offered in region ams1",
+			`droplet image "ubuntu-24-04-x64" is not available in region ams1 (available in: sfo2, nyc1)`,
+		},
+	}, {
+		desc: "region without block storage",
+		opts: []Option{DropletRegion("nyc1")},
+		want: []string{"region nyc1 does not support block storage"},
+	}, {
+		desc: "unknown size",
+		opts: []Option{DropletSize("s-huge")},
+		want: []string{`droplet size "s-huge" does not exist`},
+	}, {
+		desc: "unavailable size",
+		opts: []Option{DropletSize("s-retired")},
+		want: []string{"droplet size s-retired is not currently available"},
+	}, {
+		desc: "size not in region",
+		opts: []Option{DropletSize("s-2vcpu-2gb")},
+		want: []string{"droplet size s-2vcpu-2gb is not offered in region sfo2"},
+	}, {
+		desc: "unknown image",
+		opts: []Option{DropletImage("plan9")},
+		want: []string{`droplet image "plan9" does not exist`},
+	}, {
+		desc: "image not in region",
+		opts: []Option{DropletImage("nyc-only")},
+		want: []string{`droplet image "nyc-only" is not available in region sfo2 (available in: nyc1)`},
+	}, {
+		desc: "volume size",
+		opts: []Option{VolumeSizeGB(0)},
+		want: []string{"volume size 0GB is outside the allowed range of 1-16384GB"},
+	}, {
+		desc: "existing volume",
+		opts: []Option{ExistingVolume("vol-sfo2")},
+	}, {
+		desc: "unknown volume",
+		opts: []Option{ExistingVolume("vol-gone")},
+		want: []string{"volume vol-gone does not exist"},
+	}, {
+		desc: "volume in another region",
+		opts: []Option{ExistingVolume("vol-nyc1")},
+		want: []string{"volume old is not in region sfo2"},
+	}, {
+		desc: "snapshot too big",
+		opts: []Option{VolumeFromSnapshot("snap")},
+		want: []string{"volume size 100GB is smaller than snapshot backup's minimum of 200GB"},
+	}, {
+		desc: "HTTPS without a DNS name",
+		opts: []Option{HTTPS()},
+		want: []string{"HTTPS requires a DNS name"},
+	}, {
+		desc: "load balancer without a DNS name",
+		opts: []Option{LoadBalancer("cert")},
+		want: []string{"LoadBalancer requires a DNS name"},
+	}, {
+		desc: "every problem is reported",
+		opts: []Option{
+			DropletRegion("xyz1"),
+			DropletSize("s-huge"),
+			VolumeSizeGB(20000),
+			ExistingVolume("vol-gone"),
+			VolumeFromSnapshot("snap-gone"),
+		},
+		want: []string{
+			"volume size 20000GB is outside the allowed range of 1-16384GB",
+			`region "xyz1" does not exist`,
+			`droplet size "s-huge" does not exist`,
+			"ExistingVolume and VolumeFromSnapshot can't be used together",
+			"volume vol-gone does not exist",
+			"volume snapshot snap-gone does not exist",
+		},
+	}}
+
+	client, srv := newTestClient(func(rw http.ResponseWriter, req *http.Request) {
+		body, ok := testAPI[req.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			body = `{"id": "not_found", "message": "not found"}`
+		}
+		fmt.Fprint(rw, body)
+	})
+	defer srv.Close()
+
+	for _, tc := range testCases {
+		opt := buildOptions(tc.opts)
+		err := validate(context.Background(), client, &opt)
+		var got []string
+		if err != nil {
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Errorf("%s: got %v, want *ValidationError", tc.desc, err)
+				continue
+			}
+			for _, p := range verr.Problems {
+				got = append(got, p.Error())
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got problems %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestValidateAPIError(t *testing.T) {
+	client, srv := newTestClient(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(rw, `{"id": "server_error", "message": "unexpected error"}`)
+	})
+	defer srv.Close()
+
+	opt := buildOptions(nil)
+	err := validate(context.Background(), client, &opt)
+	if _, ok := err.(*ValidationError); ok || err == nil {
+		t.Errorf("got %v, want the API error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package dochaincore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+// ExistingVolume makes Deploy attach the existing, unattached block
+// storage volume with the provided ID instead of creating a new one.
+// The volume must be in the droplet's region. If the volume already
+// has a filesystem, its data is preserved.
+func ExistingVolume(volumeID string) Option {
+	return func(opt *options) { opt.existingVolume = volumeID }
+}
+
+// VolumeFromSnapshot makes Deploy create the Core's volume from the
+// block storage snapshot with the provided ID, preserving the data
+// in the snapshot. The snapshot must be available in the droplet's
+// region.
+func VolumeFromSnapshot(snapshotID string) Option {
+	return func(opt *options) { opt.volumeSnapshot = snapshotID }
+}
+
+// validateVolume checks the existing volume or volume snapshot
+// options, recording any problems in verr.
+func validateVolume(ctx context.Context, client *godo.Client, opt *options, verr *ValidationError) error {
+	if opt.existingVolume != "" && opt.volumeSnapshot != "" {
+		verr.addf("ExistingVolume and VolumeFromSnapshot can't be used together")
+	}
+
+	if opt.existingVolume != "" {
+		volume, _, err := client.Storage.GetVolume(ctx, opt.existingVolume)
+		switch {
+		case isNotFound(err):
+			verr.addf("volume %s does not exist", opt.existingVolume)
+		case err != nil:
+			return err
+		case volume.Region == nil || volume.Region.Slug != opt.dropletRegion:
+			verr.addf("volume %s is not in region %s", volume.Name, opt.dropletRegion)
+		}
+	}
+
+	if opt.volumeSnapshot != "" {
+		snapshot, _, err := client.Storage.GetSnapshot(ctx, opt.volumeSnapshot)
+		switch {
+		case isNotFound(err):
+			verr.addf("volume snapshot %s does not exist", opt.volumeSnapshot)
+		case err != nil:
+			return err
+		case !contains(snapshot.Regions, opt.dropletRegion):
+			verr.addf("volume snapshot %s is not available in region %s", snapshot.Name, opt.dropletRegion)
+		case opt.volumeSize < int64(snapshot.MinDiskSize):
+			verr.addf("volume size %dGB is smaller than snapshot %s's minimum of %dGB",
+				opt.volumeSize, snapshot.Name, snapshot.MinDiskSize)
+		}
+	}
+	return nil
+}
+
+// createVolume creates the Core's volume, restoring it from a
+// snapshot if one was provided.
+func createVolume(ctx context.Context, client *godo.Client, name string, opt *options) (*godo.Volume, error) {
+	description := "Chain Core storage volume"
+	if opt.volumeSnapshot != "" {
+		description = fmt.Sprintf("Chain Core storage volume restored from snapshot %s", opt.volumeSnapshot)
+	}
+	volume, _, err := client.Storage.CreateVolume(ctx, &godo.VolumeCreateRequest{
+		Region:        opt.dropletRegion,
+		Name:          name,
+		Description:   description,
+		SizeGigaBytes: opt.volumeSize,
+		SnapshotID:    opt.volumeSnapshot,
+	})
+	return volume, err
+}